@@ -0,0 +1,263 @@
+// Command buscli is a debugging client for the module bus.
+//
+//	buscli sub 'state/*'                      stream matching events
+//	buscli sub -type update 'state/*'         only events of a given type
+//	buscli pub state/dev1 update '{"on":true}'
+//	buscli call my-bundle get_config '{}'     invoke a bundle_api action
+//	buscli instances my-bundle                list a module's instances
+//
+// The bus socket defaults to $BUS_SOCKET and can be set with -socket.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+type options struct {
+	socket  string
+	id      string
+	token   string
+	timeout time.Duration
+	color   bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.socket, "socket", os.Getenv("BUS_SOCKET"), "bus Unix socket")
+	flag.StringVar(&opts.id, "id", "", "module ID to connect as (default a random buscli-<id>)")
+	flag.StringVar(&opts.token, "token", os.Getenv("BUS_TOKEN"), "bus authentication token")
+	flag.DurationVar(&opts.timeout, "timeout", 5*time.Second, "how long call and instances wait for a reply")
+	noColor := flag.Bool("no-color", false, "disable colored output")
+	flag.Usage = usage
+	flag.Parse()
+	opts.color = !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	if opts.socket == "" {
+		fail(fmt.Errorf("no bus socket: set -socket or BUS_SOCKET"))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "sub":
+		err = runSub(ctx, opts, args)
+	case "pub":
+		err = runPub(ctx, opts, args)
+	case "call":
+		err = runCall(ctx, opts, args)
+	case "instances":
+		err = runInstances(ctx, opts, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, `usage: buscli [flags] <command> [args]
+
+commands:
+  sub [-type T] <pattern>            stream events matching pattern (e.g. "state/*")
+  pub <topic> <type> [json]          publish an event
+  call <module> <action> [json]      invoke a bundle_api action and print the response
+  instances <module>                 list a module's instances
+
+flags:`)
+	flag.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "buscli:", err)
+	os.Exit(1)
+}
+
+func connect(opts options) (*framework.BusClient, error) {
+	id := opts.id
+	if id == "" {
+		id = "buscli-" + framework.GenerateID()[:8]
+	}
+	c := framework.NewBusClient(opts.socket, id)
+	c.SetOptions(framework.BusOptions{Token: opts.token})
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// closeClient flushes pending publishes before disconnecting.
+func closeClient(c *framework.BusClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Flush(ctx)
+	c.Close()
+}
+
+func parseData(s string) (map[string]any, error) {
+	if strings.TrimSpace(s) == "" {
+		return map[string]any{}, nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %w", err)
+	}
+	return data, nil
+}
+
+func runSub(ctx context.Context, opts options, args []string) error {
+	fs := flag.NewFlagSet("sub", flag.ExitOnError)
+	eventType := fs.String("type", "", "only show events of this type")
+	raw := fs.Bool("raw", false, "print events as single-line JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: sub [-type T] [-raw] <pattern>")
+	}
+
+	c, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ch, _ := c.Subscribe(fs.Arg(0))
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if *eventType != "" && ev.Type != *eventType {
+				continue
+			}
+			printEvent(opts, ev, *raw)
+		}
+	}
+}
+
+func runPub(_ context.Context, opts options, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: pub <topic> <type> [json]")
+	}
+	data := map[string]any{}
+	if len(args) == 3 {
+		var err error
+		if data, err = parseData(args[2]); err != nil {
+			return err
+		}
+	}
+	c, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+	c.Publish(args[0], args[1], data)
+	return nil
+}
+
+func runCall(ctx context.Context, opts options, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: call <module> <action> [json]")
+	}
+	params := map[string]any{}
+	if len(args) == 3 {
+		var err error
+		if params, err = parseData(args[2]); err != nil {
+			return err
+		}
+	}
+	requestID := framework.GenerateID()
+	ev, err := request(ctx, opts, "sys/bundle_api_response", func(ev framework.Event) bool {
+		return ev.Data["request_id"] == requestID
+	}, args[0], "bundle_api", map[string]any{
+		"request_id": requestID,
+		"action":     args[1],
+		"params":     params,
+	})
+	if err != nil {
+		return err
+	}
+	printJSON(ev.Data)
+	if ok, _ := ev.Data["ok"].(bool); !ok {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runInstances(ctx context.Context, opts options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: instances <module>")
+	}
+	instances, err := fetchInstances(ctx, opts, args[0])
+	if err != nil {
+		return err
+	}
+	printJSON(instances)
+	return nil
+}
+
+// fetchInstances asks module for its instances. Replies are matched on the
+// request ID, so concurrent callers never see each other's answers.
+func fetchInstances(ctx context.Context, opts options, module string) (any, error) {
+	requestID := framework.GenerateID()
+	ev, err := request(ctx, opts, "sys/instances_response", func(ev framework.Event) bool {
+		return ev.Data["bundle"] == module && ev.Data["request_id"] == requestID
+	}, module, "get_instances", map[string]any{"request_id": requestID})
+	if err != nil {
+		return nil, err
+	}
+	return ev.Data["instances"], nil
+}
+
+// request sends a command to a module and waits for the first event on
+// replyTopic accepted by match.
+func request(ctx context.Context, opts options, replyTopic string, match func(framework.Event) bool, module, cmd string, data map[string]any) (framework.Event, error) {
+	c, err := connect(opts)
+	if err != nil {
+		return framework.Event{}, err
+	}
+	defer closeClient(c)
+	replies, _ := c.Subscribe(replyTopic)
+	c.Publish("commands/"+module, cmd, data)
+	return awaitReply(ctx, replies, match, module, opts.timeout)
+}
+
+// awaitReply returns the first event from replies accepted by match.
+func awaitReply(ctx context.Context, replies <-chan framework.Event, match func(framework.Event) bool, module string, timeout time.Duration) (framework.Event, error) {
+	expired := time.After(timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return framework.Event{}, ctx.Err()
+		case <-expired:
+			return framework.Event{}, fmt.Errorf("no reply from %s within %s", module, timeout)
+		case ev, ok := <-replies:
+			if !ok {
+				return framework.Event{}, fmt.Errorf("subscription closed before %s replied", module)
+			}
+			if match(ev) {
+				return ev, nil
+			}
+		}
+	}
+}
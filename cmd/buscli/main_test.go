@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/broker"
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+// startBus serves a broker on a Unix socket and returns options pointing at it.
+func startBus(t *testing.T) (*broker.Broker, options) {
+	t.Helper()
+	// Unix socket paths are short; t.TempDir can exceed the limit.
+	dir, err := os.MkdirTemp("", "buscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "bus.sock")
+	b := broker.New()
+	t.Cleanup(b.Close)
+	go b.ListenAndServe(socket)
+	for range 100 {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return b, options{socket: socket, timeout: 2 * time.Second}
+}
+
+func TestFetchInstancesMatchesRequestID(t *testing.T) {
+	b, opts := startBus(t)
+	mod := framework.NewBusClient("", "mod")
+	if err := mod.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mod.Close)
+	commands, _ := mod.Subscribe("commands/mod")
+	go func() {
+		for ev := range commands {
+			if ev.Type != "get_instances" {
+				continue
+			}
+			// Answers to other callers arrive first and must be skipped.
+			mod.Publish("sys/instances_response", "instances", map[string]any{
+				"bundle": "mod", "request_id": "someone-else", "instances": []any{"wrong"},
+			})
+			mod.Publish("sys/instances_response", "instances", map[string]any{
+				"bundle": "other", "request_id": ev.Data["request_id"], "instances": []any{"wrong"},
+			})
+			mod.Publish("sys/instances_response", "instances", map[string]any{
+				"bundle": "mod", "request_id": ev.Data["request_id"], "instances": []any{"lamp"},
+			})
+		}
+	}()
+
+	instances, err := fetchInstances(context.Background(), opts, "mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := instances.([]any); len(list) != 1 || list[0] != "lamp" {
+		t.Fatalf("instances = %v", instances)
+	}
+}
+
+func TestAwaitReplyStopsOnClosedSubscription(t *testing.T) {
+	replies := make(chan framework.Event, 1)
+	replies <- framework.Event{Topic: "sys/instances_response", Data: map[string]any{"request_id": "other"}}
+	close(replies)
+	match := func(ev framework.Event) bool { return ev.Data["request_id"] == "mine" }
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := awaitReply(context.Background(), replies, match, "mod", time.Minute)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("awaitReply returned a reply from a closed subscription")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("awaitReply spun on a closed subscription")
+	}
+}
+
+func TestAwaitReplyTimesOut(t *testing.T) {
+	_, err := awaitReply(context.Background(), make(chan framework.Event), func(framework.Event) bool { return true }, "mod", 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("awaitReply returned without a reply")
+	}
+}
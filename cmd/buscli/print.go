@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+func paint(opts options, color, s string) string {
+	if !opts.color {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// topicColor groups events by their top-level topic segment.
+func topicColor(topic string) string {
+	switch {
+	case strings.HasPrefix(topic, "commands/"):
+		return ansiYellow
+	case strings.HasPrefix(topic, "state/"):
+		return ansiGreen
+	case strings.HasPrefix(topic, "responses/"), strings.HasPrefix(topic, "device_results/"):
+		return ansiBlue
+	case strings.HasPrefix(topic, "logs/"):
+		return ansiDim
+	case topic == "sys/crash":
+		return ansiRed
+	default:
+		return ansiCyan
+	}
+}
+
+func printEvent(opts options, ev framework.Event, raw bool) {
+	if raw {
+		data, _ := json.Marshal(ev)
+		fmt.Println(string(data))
+		return
+	}
+	ts := time.Now().Format("15:04:05.000")
+	fmt.Printf("%s %s %s\n", paint(opts, ansiDim, ts), paint(opts, topicColor(ev.Topic)+ansiBold, ev.Topic), paint(opts, ansiBold, ev.Type))
+	if len(ev.Data) > 0 {
+		data, _ := json.MarshalIndent(ev.Data, "  ", "  ")
+		fmt.Printf("  %s\n", data)
+	}
+}
+
+func printJSON(v any) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
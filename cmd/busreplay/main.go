@@ -0,0 +1,133 @@
+// Command busreplay feeds a bus recording (see BUS_RECORD) back into a module.
+//
+// Serve a bus for the module under test and replay once it has connected:
+//
+//	busreplay -listen /tmp/bus.sock -speed 10 session.jsonl
+//	BUS_SOCKET=/tmp/bus.sock MODULE_ID=... STATE_DIR=... ./my-bundle
+//
+// Or publish into a running bus:
+//
+//	busreplay -socket $BUS_SOCKET session.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/broker"
+	"github.com/lms-io/module-framework/pkg/framework"
+	"github.com/lms-io/module-framework/pkg/replay"
+)
+
+func main() {
+	listen := flag.String("listen", "", "serve a bus on this Unix socket and wait for the module to connect")
+	socket := flag.String("socket", os.Getenv("BUS_SOCKET"), "publish into an existing bus on this Unix socket")
+	wait := flag.Int("wait", 1, "with -listen, number of connections to wait for before replaying")
+	speed := flag.Float64("speed", 1, "replay speed factor; 0 replays without delay")
+	echoes := flag.Bool("echoes", false, "also replay inbound echoes of the module's own publishes")
+	record := flag.String("record", "", "record all bus traffic during the replay to this file")
+	linger := flag.Duration("linger", 2*time.Second, "keep running after the last event so responses can arrive")
+	list := flag.Bool("list", false, "print the events that would be replayed and exit")
+	legacy := flag.Bool("legacy", false, "with -socket, skip the handshake so the broker keeps the recorded sources")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: busreplay [flags] recording.jsonl\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	records, err := replay.Load(flag.Arg(0))
+	if err != nil {
+		fatal("load recording", err)
+	}
+	if *list {
+		replay.WriteSummary(os.Stdout, records, *echoes)
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var sink replay.Sink
+	var observer *framework.BusClient
+	if *listen != "" {
+		b := broker.New()
+		defer b.Close()
+		go func() {
+			if err := b.ListenAndServe(*listen); err != nil && err != broker.ErrClosed {
+				fatal("serve bus", err)
+			}
+		}()
+		slog.Info("waiting for module", "socket", *listen, "connections", *wait)
+		if err := b.WaitConnections(ctx, *wait); err != nil {
+			fatal("wait for module", err)
+		}
+		if *record != "" {
+			observer = framework.NewBusClient("", "busreplay")
+			if err := attachRecorder(observer, *record); err != nil {
+				fatal("open recording", err)
+			}
+			if err := observer.StartConn(b.Pipe()); err != nil {
+				fatal("connect recorder", err)
+			}
+		}
+		sink = replay.BrokerSink(b)
+	} else {
+		if *socket == "" {
+			fatal("connect", fmt.Errorf("one of -listen or -socket (or BUS_SOCKET) is required"))
+		}
+		client := framework.NewBusClient(*socket, "busreplay")
+		client.SetOptions(framework.BusOptions{Legacy: *legacy})
+		if *record != "" {
+			if err := attachRecorder(client, *record); err != nil {
+				fatal("open recording", err)
+			}
+		}
+		if err := client.Start(); err != nil {
+			fatal("connect", err)
+		}
+		observer = client
+		sink = replay.ClientSink(client)
+	}
+
+	player := replay.Player{Speed: *speed, IncludeEchoes: *echoes}
+	started := time.Now()
+	if err := player.Play(ctx, records, sink); err != nil {
+		fatal("replay", err)
+	}
+	slog.Info("replay finished", "events", len(replay.Inbound(records, *echoes)), "elapsed", time.Since(started).Round(time.Millisecond))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(*linger):
+	}
+	if observer != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), time.Second)
+		observer.Flush(flushCtx)
+		cancelFlush()
+		observer.Close()
+	}
+}
+
+func attachRecorder(c *framework.BusClient, path string) error {
+	rec, err := framework.CreateRecording(path, "busreplay")
+	if err != nil {
+		return err
+	}
+	c.SetRecorder(rec)
+	return nil
+}
+
+func fatal(what string, err error) {
+	slog.Error(what+" failed", "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,535 @@
+// Package broker is a reference implementation of the module bus. It fans
+// every event out to all connections, including the sender, as
+// newline-delimited JSON, and can serve both Unix sockets and in-process pipes.
+// Clients introduce themselves with a sys/hello as their first frame and may
+// negotiate length-prefixed framing and a binary codec there; clients that
+// skip the hello are served as line-framed JSON. With a Policy the broker
+// asks for the client's token on sys/auth before welcoming it.
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+const connQueueSize = 1024
+
+var ErrClosed = errors.New("broker closed")
+
+type Broker struct {
+	mu        sync.Mutex
+	conns     map[*conn]struct{}
+	listeners []net.Listener
+	closed    bool
+	log       *slog.Logger
+	retained  map[retainKey]framework.Event
+
+	// MaxFrameSize bounds frames read from and written to each client
+	// (framework.DefaultMaxFrameSize if 0). Set it before serving.
+	MaxFrameSize int
+	// Policy, when set, requires every client to authenticate in the
+	// handshake and enforces its topic ACLs. Set it before serving.
+	Policy *Policy
+}
+
+func New() *Broker {
+	return &Broker{
+		conns:    make(map[*conn]struct{}),
+		retained: make(map[retainKey]framework.Event),
+		log:      slog.Default().With("component", "broker"),
+	}
+}
+
+// conn is one connected client with its own writer so a slow peer cannot
+// stall fan-out to the others.
+type conn struct {
+	nc     net.Conn
+	module string          // from the hello, guarded by Broker.mu
+	mode   string          // framing, guarded by Broker.mu
+	codec  framework.Codec // guarded by Broker.mu
+	acl    *framework.ACL  // nil if unrestricted, guarded by Broker.mu
+	out    chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.nc.Close()
+	})
+}
+
+// ListenAndServe serves the bus on a Unix socket at path, replacing a stale socket file.
+func (b *Broker) ListenAndServe(path string) error {
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return b.Serve(ln)
+}
+
+// Serve accepts connections on ln until the broker is closed.
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	b.listeners = append(b.listeners, ln)
+	b.mu.Unlock()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		if c := b.register(nc); c != nil {
+			go b.serve(c)
+		}
+	}
+}
+
+// Pipe connects an in-process client and returns its end of the connection,
+// ready for framework.BusClient.StartConn. The client is registered before
+// Pipe returns, so it sees every event published afterwards.
+func (b *Broker) Pipe() net.Conn {
+	client, server := net.Pipe()
+	if c := b.register(server); c != nil {
+		go b.serve(c)
+	}
+	return client
+}
+
+// retainKey identifies a retained event: the latest per topic and publisher.
+type retainKey struct {
+	topic, source string
+}
+
+// Publish injects an event as if a client had sent it.
+func (b *Broker) Publish(ev framework.Event) {
+	if ev.Retain {
+		b.retain(ev)
+	}
+	b.fanout(ev)
+}
+
+// retain stores ev as the latest for its topic and publisher; a clear removes
+// the entry. Clears are still fanned out, as events of type ClearType.
+func (b *Broker) retain(ev framework.Event) {
+	key := retainKey{ev.Topic, ev.Source}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if framework.IsRetainedClear(ev) {
+		delete(b.retained, key)
+		return
+	}
+	b.retained[key] = ev
+}
+
+// sendRetained answers a retained request with the matching events the
+// client may receive, on its connection only.
+func (b *Broker) sendRetained(c *conn, pattern, subID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []framework.Event
+	for key, ev := range b.retained {
+		if framework.TopicMatches(pattern, key.topic) && (c.acl == nil || c.acl.CanSubscribe(key.topic)) {
+			events = append(events, ev)
+		}
+	}
+	slices.SortFunc(events, func(x, y framework.Event) int { return strings.Compare(x.Topic, y.Topic) })
+	b.sendLocked(c, framework.NewRetainedSnapshot(subID, events))
+}
+
+// Connections reports how many clients are connected.
+func (b *Broker) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.conns)
+}
+
+// WaitConnections blocks until at least n clients are connected.
+func (b *Broker) WaitConnections(ctx context.Context, n int) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for b.Connections() < n {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close stops all listeners and disconnects every client.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	b.closed = true
+	lns := b.listeners
+	conns := make([]*conn, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.mu.Unlock()
+	for _, ln := range lns {
+		ln.Close()
+	}
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+// register adds a connection to the fan-out set, or closes it and returns nil
+// if the broker is closed.
+func (b *Broker) register(nc net.Conn) *conn {
+	c := &conn{nc: nc, mode: framework.FramingLine, codec: framework.JSONCodec, out: make(chan []byte, connQueueSize), done: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		nc.Close()
+		return nil
+	}
+	b.conns[c] = struct{}{}
+	return c
+}
+
+func (b *Broker) serve(c *conn) {
+	nc := c.nc
+	go b.writeLoop(c)
+	var module string
+	var acl *framework.ACL
+	var will []framework.Event
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		closed := b.closed
+		b.mu.Unlock()
+		c.close()
+		// No bye before the connection dropped: publish the will, unless the
+		// broker itself is going away.
+		if len(will) > 0 && !closed {
+			b.log.Warn("client disconnected without bye, publishing will", "module", module, "events", len(will))
+			for _, ev := range will {
+				b.Publish(ev)
+			}
+		}
+	}()
+
+	fr := framework.NewFrameReader(nc, framework.FramingLine, b.MaxFrameSize)
+	codec := framework.JSONCodec
+	first := true
+	var pending *framework.Hello // hello waiting for its token
+	admit := func(h framework.Hello, w framework.Welcome) {
+		codec = b.accept(c, h.Module, w)
+		module, acl = h.Module, w.ACL
+		will = b.checkWill(module, acl, h.Will)
+		fr.SetMode(w.Framing)
+	}
+	for {
+		frame, err := fr.ReadFrame()
+		if errors.Is(err, framework.ErrFrameTooLarge) {
+			b.log.Warn("dropping oversized frame", "max_frame", b.maxFrame())
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+				b.log.Warn("connection read failed", "error", err)
+			}
+			return
+		}
+		var ev framework.Event
+		if jerr := codec.Unmarshal(frame, &ev); jerr != nil {
+			b.log.Warn("dropping malformed event", "error", jerr)
+			continue
+		}
+		if h, ok := framework.ParseHello(ev); ok && first {
+			first = false
+			w := b.welcome(h)
+			if w.Error != "" {
+				b.log.Warn("rejected client", "module", h.Module, "version", h.Version, "reason", w.Error)
+				b.reject(c, w)
+				return
+			}
+			if b.Policy != nil {
+				pending = &h
+				b.requestAuth(c)
+				continue
+			}
+			admit(h, w)
+			continue
+		}
+		if pending != nil {
+			h := *pending
+			pending = nil
+			token, ok := framework.ParseAuth(ev)
+			w := b.welcome(h)
+			if !ok {
+				w.Error = "authentication required"
+			} else if a, err := b.Policy.authenticate(h.Module, token); err != nil {
+				w.Error = err.Error()
+			} else {
+				w.ACL = &a
+			}
+			if w.Error != "" {
+				b.log.Warn("rejected client", "module", h.Module, "version", h.Version, "reason", w.Error)
+				b.reject(c, framework.Welcome{Protocol: w.Protocol, Error: w.Error})
+				return
+			}
+			admit(h, w)
+			continue
+		}
+		if first && b.Policy != nil {
+			b.log.Warn("rejected client without handshake")
+			b.reject(c, framework.Welcome{Protocol: framework.ProtocolVersion, Error: "handshake required"})
+			return
+		}
+		first = false
+		if ev.Topic == framework.HelloTopic || ev.Topic == framework.AuthTopic {
+			// A repeated hello or a stray token is not traffic: fanning it
+			// out would leak the token or let a module re-introduce itself.
+			b.deny(c, module, ev, errors.New("handshake already done"))
+			continue
+		}
+		if pattern, subID, ok := framework.ParseRetainedRequest(ev); ok {
+			b.sendRetained(c, pattern, subID)
+			continue
+		}
+		if events, ok := framework.ParseWill(ev); ok {
+			will = b.checkWill(module, acl, events)
+			continue
+		}
+		if ev.Topic == framework.ByeTopic {
+			will = nil
+			continue
+		}
+		if module != "" {
+			ev.Source = module
+		}
+		if acl != nil {
+			if err := checkPublish(module, *acl, ev); err != nil {
+				b.deny(c, module, ev, err)
+				continue
+			}
+		}
+		b.Publish(ev)
+	}
+}
+
+// capabilities are the handshake capabilities the broker supports.
+var capabilities = []string{framework.CapWildcards, framework.CapRequestReply, framework.CapACL, framework.CapRetain, framework.CapWill}
+
+// welcome decides how to answer a hello. With a Policy the module still has
+// to authenticate before it is welcomed.
+func (b *Broker) welcome(h framework.Hello) framework.Welcome {
+	w := framework.Welcome{
+		Version:  framework.FrameworkVersion,
+		Protocol: framework.ProtocolVersion,
+		Framing:  framework.FramingLine,
+		Codec:    framework.CodecJSON,
+		MaxFrame: b.maxFrame(),
+	}
+	switch {
+	case h.Protocol != framework.ProtocolVersion:
+		w.Error = fmt.Sprintf("unsupported protocol version %d, broker speaks %d", h.Protocol, framework.ProtocolVersion)
+		return w
+	case h.Module == "":
+		w.Error = "missing module ID"
+		return w
+	}
+	if b.Policy != nil {
+		// Refuse unknown modules before they send a token.
+		if _, err := b.Policy.lookup(h.Module); err != nil {
+			w.Error = err.Error()
+			return w
+		}
+	}
+	for _, c := range h.Capabilities {
+		if slices.Contains(capabilities, c) {
+			w.Capabilities = append(w.Capabilities, c)
+		}
+	}
+	if slices.Contains(h.Framing, framework.FramingLength) {
+		w.Framing = framework.FramingLength
+		// Binary codecs need length framing; the client lists codecs by preference.
+		for _, name := range h.Codecs {
+			if _, ok := framework.CodecByName(name); ok {
+				w.Codec = name
+				break
+			}
+		}
+	}
+	return w
+}
+
+// accept answers a hello on its connection only and switches the connection
+// to the agreed framing and codec. The welcome is queued before the switch so
+// it still goes out as line-framed JSON.
+func (b *Broker) accept(c *conn, module string, w framework.Welcome) framework.Codec {
+	codec, _ := framework.CodecByName(w.Codec)
+	reply, _ := json.Marshal(w.Event())
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case c.out <- append(reply, '\n'):
+	default:
+		b.log.Warn("client queue full, dropping welcome")
+	}
+	c.module, c.mode, c.codec, c.acl = module, w.Framing, codec, w.ACL
+	b.log.Debug("client connected", "module", module, "framing", w.Framing, "codec", w.Codec)
+	return codec
+}
+
+// requestAuth asks the client for its token, on its connection only.
+func (b *Broker) requestAuth(c *conn) {
+	reply, _ := json.Marshal(framework.Welcome{Protocol: framework.ProtocolVersion, Auth: true}.Event())
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case c.out <- append(reply, '\n'):
+	default:
+		b.log.Warn("client queue full, dropping auth request")
+	}
+}
+
+// reject tells the client why it is refused; the caller then closes the
+// connection. It bypasses the queue so the reason is written before the close.
+func (b *Broker) reject(c *conn, w framework.Welcome) {
+	reply, _ := json.Marshal(w.Event())
+	c.nc.SetWriteDeadline(time.Now().Add(time.Second))
+	c.nc.Write(append(reply, '\n'))
+}
+
+// checkWill stamps will events with the module ID and drops those the module
+// could not publish itself. Only clients that introduced themselves may have
+// a will.
+func (b *Broker) checkWill(module string, acl *framework.ACL, events []framework.Event) []framework.Event {
+	if module == "" {
+		return nil
+	}
+	var will []framework.Event
+	for _, ev := range events {
+		ev.Source = module
+		if acl != nil {
+			if err := checkPublish(module, *acl, ev); err != nil {
+				b.log.Warn("dropping will event", "module", module, "topic", ev.Topic, "reason", err)
+				continue
+			}
+		}
+		will = append(will, ev)
+	}
+	return will
+}
+
+// deny drops an event the sender may not publish and tells the sender why on
+// sys/denied.
+func (b *Broker) deny(c *conn, module string, ev framework.Event, reason error) {
+	b.log.Warn("denied publish", "module", module, "topic", ev.Topic, "reason", reason)
+	notice := framework.Event{Topic: "sys/denied", Type: "publish", Data: map[string]any{
+		"topic": ev.Topic,
+		"error": reason.Error(),
+	}}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sendLocked(c, notice)
+}
+
+// sendLocked queues ev for one connection; b.mu must be held.
+func (b *Broker) sendLocked(c *conn, ev framework.Event) {
+	payload, err := c.codec.Marshal(ev)
+	if err != nil {
+		b.log.Error("encode event", "topic", ev.Topic, "codec", c.codec.Name(), "error", err)
+		return
+	}
+	data, err := framework.AppendFrame(nil, c.mode, payload, b.maxFrame())
+	if err != nil {
+		b.log.Warn("dropping oversized event", "topic", ev.Topic, "size", len(payload), "max_frame", b.maxFrame())
+		return
+	}
+	select {
+	case c.out <- data:
+	default:
+		b.log.Warn("client queue full, dropping event")
+	}
+}
+
+func (b *Broker) maxFrame() int {
+	if b.MaxFrameSize > 0 {
+		return b.MaxFrameSize
+	}
+	return framework.DefaultMaxFrameSize
+}
+
+func (b *Broker) writeLoop(c *conn) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.out:
+			if _, err := c.nc.Write(data); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// fanout encodes ev once per framing and codec in use and queues it on every
+// connection.
+func (b *Broker) fanout(ev framework.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	type encoding struct {
+		mode  string
+		codec framework.Codec
+	}
+	frames := make(map[encoding][]byte, 2)
+	for c := range b.conns {
+		if b.Policy != nil && c.module == "" {
+			continue // not authenticated yet
+		}
+		if c.acl != nil && !c.acl.CanSubscribe(ev.Topic) {
+			continue
+		}
+		enc := encoding{c.mode, c.codec}
+		data, ok := frames[enc]
+		if !ok {
+			payload, err := c.codec.Marshal(ev)
+			if err != nil {
+				b.log.Error("encode event", "topic", ev.Topic, "codec", c.codec.Name(), "error", err)
+				continue
+			}
+			data, err = framework.AppendFrame(nil, c.mode, payload, b.maxFrame())
+			if err != nil {
+				b.log.Warn("dropping oversized event", "topic", ev.Topic, "size", len(payload), "max_frame", b.maxFrame())
+				continue
+			}
+			frames[enc] = data
+		}
+		select {
+		case c.out <- data:
+		default:
+			b.log.Warn("client queue full, dropping event")
+		}
+	}
+}
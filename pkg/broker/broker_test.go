@@ -0,0 +1,343 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+func startClient(t *testing.T, b *Broker, id string) *framework.BusClient {
+	t.Helper()
+	c := framework.NewBusClient("", id)
+	if err := c.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func receive(t *testing.T, ch <-chan framework.Event) framework.Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return framework.Event{}
+	}
+}
+
+func TestBrokerFansOutToAllClients(t *testing.T) {
+	b := New()
+	defer b.Close()
+	a := startClient(t, b, "a")
+	c := startClient(t, b, "c")
+
+	fromA, _ := a.Subscribe("state/*")
+	fromC, _ := c.Subscribe("state/*")
+	a.Publish("state/dev1", "update", map[string]any{"on": true})
+
+	for _, ch := range []<-chan framework.Event{fromA, fromC} {
+		ev := receive(t, ch)
+		if ev.Topic != "state/dev1" || ev.Data["on"] != true {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+
+	b.Publish(framework.Event{Topic: "state/dev2", Type: "update"})
+	if ev := receive(t, fromC); ev.Topic != "state/dev2" {
+		t.Fatalf("unexpected injected event %+v", ev)
+	}
+}
+
+func TestBrokerNegotiatesLengthFraming(t *testing.T) {
+	b := New()
+	defer b.Close()
+	line := startClient(t, b, "line")
+	long := framework.NewBusClient("", "long")
+	long.SetOptions(framework.BusOptions{Framing: framework.FramingLength})
+	if err := long.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(long.Close)
+	f := long.Features()
+	if f.Framing != framework.FramingLength {
+		t.Fatalf("negotiated framing = %q, want %q", f.Framing, framework.FramingLength)
+	}
+	if !f.Handshake || !f.Has(framework.CapWildcards) {
+		t.Fatalf("unexpected features %+v", f)
+	}
+
+	// Well over the 64 KiB a default bufio.Scanner accepts.
+	script := strings.Repeat("a", 256<<10)
+	fromLine, _ := line.Subscribe("state/*")
+	long.Publish("state/script", "update", map[string]any{"content": script})
+	if ev := receive(t, fromLine); ev.Data["content"] != script {
+		t.Fatalf("large payload not delivered intact (%d bytes)", len(asString(ev.Data["content"])))
+	}
+}
+
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func TestBrokerBridgesCodecs(t *testing.T) {
+	b := New()
+	defer b.Close()
+	plain := startClient(t, b, "plain")
+	binary := framework.NewBusClient("", "binary")
+	binary.SetOptions(framework.BusOptions{Framing: framework.FramingLength, Codec: framework.CodecMsgpack})
+	if err := binary.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(binary.Close)
+	if got := binary.Features().Codec; got != framework.CodecMsgpack {
+		t.Fatalf("negotiated codec = %q, want %q", got, framework.CodecMsgpack)
+	}
+
+	fromPlain, _ := plain.Subscribe("state/*")
+	fromBinary, _ := binary.Subscribe("state/*")
+	binary.Publish("state/dev1", "update", map[string]any{"level": 42})
+	if ev := receive(t, fromBinary); ev.Data["level"] != int64(42) {
+		t.Fatalf("binary client got %#v, want int64(42)", ev.Data["level"])
+	}
+	if ev := receive(t, fromPlain); ev.Data["level"] != float64(42) {
+		t.Fatalf("json client got %#v, want float64(42)", ev.Data["level"])
+	}
+}
+
+func TestBrokerRejectsIncompatibleProtocol(t *testing.T) {
+	b := New()
+	defer b.Close()
+	conn := b.Pipe()
+	defer conn.Close()
+
+	hello := framework.Hello{Module: "future", Protocol: framework.ProtocolVersion + 1}
+	data, _ := json.Marshal(hello.Event())
+	go conn.Write(append(data, '\n'))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev framework.Event
+	if err := json.Unmarshal(line, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Topic != framework.WelcomeTopic || ev.Type != "reject" || !strings.Contains(asString(ev.Data["error"]), "protocol") {
+		t.Fatalf("unexpected answer %+v", ev)
+	}
+}
+
+func TestBrokerEnforcesPolicy(t *testing.T) {
+	b := New()
+	defer b.Close()
+	b.Policy = &Policy{Modules: map[string]ModulePolicy{
+		"hue":   {Token: "hue-token", ACL: framework.ACL{Publish: []string{"state/*", "sys/*"}}},
+		"evil":  {Token: "evil-token", ACL: framework.ACL{Publish: []string{"sys/*"}}},
+		"watch": {Token: "watch-token", ACL: framework.ACL{Publish: []string{}, Subscribe: []string{"sys/*"}}},
+	}}
+	connect := func(id, token string) (*framework.BusClient, error) {
+		c := framework.NewBusClient("", id)
+		c.SetOptions(framework.BusOptions{Token: token})
+		if err := c.StartConn(b.Pipe()); err != nil {
+			return nil, err
+		}
+		t.Cleanup(c.Close)
+		return c, nil
+	}
+
+	if _, err := connect("hue", "wrong"); !errors.Is(err, framework.ErrHandshakeRejected) {
+		t.Fatalf("bad token error = %v, want ErrHandshakeRejected", err)
+	}
+	hue, err := connect("hue", "hue-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	evil, err := connect("evil", "evil-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := connect("watch", "watch-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl := watch.Features().ACL; acl == nil || acl.CanPublish("state/x") {
+		t.Fatalf("watch ACL = %+v, want publish denied", acl)
+	}
+
+	events, _ := watch.Subscribe("*")
+	denied, _ := evil.Subscribe("sys/denied")
+	evil.Publish("sys/register", "register", map[string]any{"bundle": "hue"})
+	hue.Publish("state/light", "update", nil) // outside watch's subscribe ACL
+	hue.Publish("sys/register", "register", map[string]any{"bundle": "hue"})
+
+	ev := receive(t, events)
+	if ev.Topic != "sys/register" || ev.Source != "hue" {
+		t.Fatalf("watch got %+v, want hue's sys/register", ev)
+	}
+	if ev := receive(t, denied); ev.Data["topic"] != "sys/register" {
+		t.Fatalf("unexpected denial %+v", ev)
+	}
+}
+
+func TestBrokerDropsRepeatedHello(t *testing.T) {
+	b := New()
+	defer b.Close()
+	b.Policy = &Policy{Default: &ModulePolicy{Token: "s3cret"}}
+	connect := func(id string) *framework.BusClient {
+		c := framework.NewBusClient("", id)
+		c.SetOptions(framework.BusOptions{Token: "s3cret"})
+		if err := c.StartConn(b.Pipe()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(c.Close)
+		return c
+	}
+	mod, watch := connect("mod"), connect("watch")
+	events, _ := watch.Subscribe("*")
+	denied, _ := mod.Subscribe("sys/denied")
+
+	mod.Publish(framework.HelloTopic, "hello", map[string]any{"module": "other", "protocol": framework.ProtocolVersion})
+	mod.Publish(framework.AuthTopic, "auth", map[string]any{"token": "s3cret"})
+	mod.Publish("state/lamp", "update", nil)
+
+	if ev := receive(t, events); ev.Topic != "state/lamp" {
+		t.Fatalf("watch got %+v, want only regular traffic", ev)
+	}
+	for _, topic := range []string{framework.HelloTopic, framework.AuthTopic} {
+		if ev := receive(t, denied); ev.Data["topic"] != topic {
+			t.Fatalf("denial %+v, want one for %s", ev, topic)
+		}
+	}
+}
+
+func TestBrokerRejectsRetainedRequestBeforeHandshake(t *testing.T) {
+	b := New()
+	defer b.Close()
+	b.Policy = &Policy{Default: &ModulePolicy{Token: "s3cret"}}
+	b.Publish(framework.Event{Topic: "state/lamp", Type: "update", Retain: true, Data: map[string]any{"on": true}})
+
+	conn := b.Pipe()
+	defer conn.Close()
+	req := framework.Event{Topic: framework.RetainedTopic, Type: "request", Data: map[string]any{"pattern": "*", "sub": "s1"}}
+	data, _ := json.Marshal(req)
+	go conn.Write(append(data, '\n'))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev framework.Event
+	if err := json.Unmarshal(line, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Topic != framework.WelcomeTopic || ev.Type != "reject" {
+		t.Fatalf("unauthenticated retained request answered with %+v", ev)
+	}
+}
+
+func TestBrokerDeliversRetainedSnapshotFirst(t *testing.T) {
+	b := New()
+	defer b.Close()
+	pub := startClient(t, b, "pub")
+	sync, _ := pub.Subscribe("state/*")
+	pub.PublishRetained("state/lamp", "update", map[string]any{"on": true})
+	pub.PublishRetained("state/gone", "update", map[string]any{"on": true})
+	pub.PublishRetained("state/gone", "update", nil)
+	clears := 0
+	for range 3 {
+		// The broker has stored all three once they echo back.
+		if ev := receive(t, sync); ev.Type == framework.ClearType && ev.Data == nil {
+			clears++
+		}
+	}
+	if clears != 1 {
+		t.Fatalf("got %d events of type %q, want the clear fanned out once", clears, framework.ClearType)
+	}
+
+	sub := startClient(t, b, "sub")
+	ch, _ := sub.Subscribe("state/*", framework.WithRetained())
+	pub.Publish("state/lamp", "update", map[string]any{"on": false})
+
+	first := receive(t, ch)
+	if first.Topic != "state/lamp" || first.Data["on"] != true || !first.Retain {
+		t.Fatalf("first event = %+v, want retained lamp state", first)
+	}
+	if next := receive(t, ch); next.Data["on"] != false {
+		t.Fatalf("second event = %+v, want live lamp update", next)
+	}
+}
+
+func TestBrokerPublishesWillOnlyOnUncleanDisconnect(t *testing.T) {
+	b := New()
+	defer b.Close()
+	watch := startClient(t, b, "watch")
+	status, _ := watch.Subscribe("sys/bundle_status")
+
+	connectWithWill := func(id string) (*framework.BusClient, net.Conn) {
+		c := framework.NewBusClient("", id)
+		c.SetWill(framework.Event{Topic: "sys/bundle_status", Type: "status", Data: map[string]any{"bundle": id, "state": "error"}})
+		conn := b.Pipe()
+		if err := c.StartConn(conn); err != nil {
+			t.Fatal(err)
+		}
+		return c, conn
+	}
+
+	clean, _ := connectWithWill("clean")
+	clean.Close()
+	_, conn := connectWithWill("crashed")
+	conn.Close() // drop the connection without a bye
+
+	ev := receive(t, status)
+	if ev.Data["bundle"] != "crashed" || ev.Source != "crashed" {
+		t.Fatalf("will = %+v, want the crashed module's status", ev)
+	}
+	select {
+	case ev := <-status:
+		t.Fatalf("unexpected will %+v after clean Close", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionSourceFilters(t *testing.T) {
+	b := New()
+	defer b.Close()
+	self := startClient(t, b, "self")
+	other := startClient(t, b, "other")
+	third := startClient(t, b, "third")
+
+	notSelf, _ := self.Subscribe("state/*", framework.ExcludeSelf())
+	onlyThird, _ := self.Subscribe("state/*", framework.FromSources("third"))
+	none, _ := self.Subscribe("state/*", framework.FromSources())
+	self.Publish("state/a", "update", nil)
+	other.Publish("state/b", "update", nil)
+	third.Publish("state/c", "update", nil)
+
+	if ev := receive(t, notSelf); ev.Source == "self" {
+		t.Fatalf("ExcludeSelf delivered own event %+v", ev)
+	}
+	if ev := receive(t, notSelf); ev.Source == "self" {
+		t.Fatalf("ExcludeSelf delivered own event %+v", ev)
+	}
+	if ev := receive(t, onlyThird); ev.Source != "third" {
+		t.Fatalf("FromSources delivered %+v", ev)
+	}
+	select {
+	case ev := <-onlyThird:
+		t.Fatalf("FromSources delivered extra event %+v", ev)
+	case ev := <-none:
+		t.Fatalf("empty FromSources delivered %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+// Policy authenticates modules in the handshake and limits the topics they
+// may publish and receive. A broker without a policy accepts everyone.
+type Policy struct {
+	Modules map[string]ModulePolicy `json:"modules"`
+	// Default applies to modules not listed in Modules; nil rejects them.
+	Default *ModulePolicy `json:"default,omitempty"`
+}
+
+// ModulePolicy is the token and ACL of one module. An empty Token accepts any
+// token.
+type ModulePolicy struct {
+	Token string `json:"token,omitempty"`
+	framework.ACL
+}
+
+var errAuthFailed = errors.New("authentication failed")
+
+// LoadPolicy reads a policy from a JSON file such as
+//
+//	{"modules": {"hue": {"token": "s3cret", "publish": ["state/*", "sys/*"]}}}
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// authenticate checks a module's token and returns its ACL with "{module}"
+// expanded.
+func (p *Policy) authenticate(module, token string) (framework.ACL, error) {
+	mp, err := p.lookup(module)
+	if err != nil {
+		return framework.ACL{}, err
+	}
+	if mp.Token != "" && subtle.ConstantTimeCompare([]byte(mp.Token), []byte(token)) != 1 {
+		return framework.ACL{}, errAuthFailed
+	}
+	return mp.ACL.Expand(module), nil
+}
+
+// lookup returns the policy of a module, or an error if it may not connect.
+func (p *Policy) lookup(module string) (ModulePolicy, error) {
+	if mp, ok := p.Modules[module]; ok {
+		return mp, nil
+	}
+	if p.Default == nil {
+		return ModulePolicy{}, fmt.Errorf("unknown module %q", module)
+	}
+	return *p.Default, nil
+}
+
+// checkPublish enforces the sender's ACL and stops a module from speaking for
+// another bundle on sys/ topics.
+func checkPublish(module string, acl framework.ACL, ev framework.Event) error {
+	if !acl.CanPublish(ev.Topic) {
+		return fmt.Errorf("publish to %s not allowed", ev.Topic)
+	}
+	if strings.HasPrefix(ev.Topic, "sys/") {
+		if bundle, ok := ev.Data["bundle"].(string); ok && bundle != module {
+			return fmt.Errorf("%s cannot publish for bundle %q", ev.Topic, bundle)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Error codes reported in command acknowledgements.
+const (
+	ErrCodeInvalidRequest = "invalid_request"   // Missing or malformed command fields
+	ErrCodeValidation     = "validation_failed" // ValidateConfig rejected the config
+	ErrCodeRejected       = "rejected"          // PrepareInstance rejected the instance
+	ErrCodeNotFound       = "not_found"         // Referenced instance does not exist
+	ErrCodeUnsupported    = "unsupported"       // Unknown command, action or tool
+	ErrCodeBusy           = "busy"              // Init or the previous Stop still in progress
+	ErrCodePanic          = "panic"             // A handler callback panicked
+	ErrCodeInternal       = "internal"          // Any other failure
+)
+
+// CommandError carries a machine-readable code for a failed command.
+type CommandError struct {
+	Code string
+	Err  error
+}
+
+func (e *CommandError) Error() string { return e.Err.Error() }
+func (e *CommandError) Unwrap() error { return e.Err }
+
+func commandErrorf(code, format string, args ...any) *CommandError {
+	return &CommandError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// errorCode maps an error returned by a command to its acknowledgement code.
+func errorCode(err error) string {
+	var cerr *CommandError
+	var perr *PanicError
+	switch {
+	case errors.As(err, &cerr):
+		return cerr.Code
+	case errors.As(err, &perr):
+		return ErrCodePanic
+	case errors.Is(err, errInitInProgress), errors.Is(err, errStopRunning):
+		return ErrCodeBusy
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// ResponseTopic is the topic on which a module acknowledges its commands.
+func ResponseTopic(moduleID string) string {
+	return "responses/" + moduleID
+}
+
+// ack publishes the outcome of a command on the module's response topic.
+func (r *runner) ack(ctx context.Context, ev Event, result map[string]any, err error) {
+	ack := CommandAck{
+		Bundle:    r.cfg.ModuleID,
+		Command:   ev.Type,
+		RequestID: asString(ev.Data["request_id"]),
+		OK:        err == nil,
+	}
+	if err != nil {
+		r.base.log.Error("command failed", "command", ev.Type, "request_id", ack.RequestID, "error_code", errorCode(err), "error", err)
+		ack.ErrorCode = errorCode(err)
+		ack.Error = err.Error()
+	} else {
+		ack.Result = result
+	}
+	data, encErr := EncodePayload(ack)
+	if encErr != nil {
+		// A bundle returned a result that cannot be serialized.
+		r.base.log.Error("command result not encodable", "command", ev.Type, "error", encErr)
+		ack.OK, ack.Result = false, nil
+		ack.ErrorCode, ack.Error = ErrCodeInternal, "encode result: "+encErr.Error()
+		data = mustEncode(ack)
+	}
+	r.base.PublishContext(ctx, ResponseTopic(r.cfg.ModuleID), "ack", data)
+}
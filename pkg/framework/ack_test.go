@@ -0,0 +1,80 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// preparingHandler rejects instances named "bad" in PrepareInstance.
+type preparingHandler struct{ scriptedHandler }
+
+func (*preparingHandler) PrepareInstance(payload InstanceConfig) (InstanceConfig, error) {
+	if payload.Name == "bad" {
+		return payload, errors.New("unsupported model")
+	}
+	payload.Meta = map[string]any{"prepared": true}
+	return payload, nil
+}
+
+func TestRunnerAcksCommands(t *testing.T) {
+	handler := &preparingHandler{scriptedHandler{validate: func(_ context.Context, config map[string]any) error {
+		if config["host"] == "" {
+			return errors.New("host is required")
+		}
+		return nil
+	}}}
+	_, peer := startTestRunner(t, handler, RunnerConfig{})
+
+	tests := []struct {
+		command string
+		data    map[string]any
+		code    string // empty for success
+	}{
+		{"register_instance", map[string]any{"id": "lamp", "name": "Lamp"}, ""},
+		{"register_instance", map[string]any{"id": "toaster", "name": "bad"}, ErrCodeRejected},
+		{"set_config", map[string]any{"config": map[string]any{"host": "10.0.0.2"}}, ""},
+		{"set_config", map[string]any{"config": map[string]any{"host": ""}}, ErrCodeValidation},
+		{"delete_instance", map[string]any{"id": "lamp"}, ""},
+		{"delete_instance", map[string]any{}, ErrCodeInvalidRequest},
+		{"reboot", nil, ErrCodeUnsupported},
+	}
+	for i, tt := range tests {
+		requestID := fmt.Sprintf("%s-%d", tt.command, i)
+		sendCommand(peer, tt.command, requestID, tt.data)
+		ack := nextAck(t, peer, requestID)
+		if ack.Bundle != "mod" || ack.Command != tt.command || ack.RequestID != requestID {
+			t.Fatalf("%s: ack = %+v", requestID, ack)
+		}
+		if ack.OK != (tt.code == "") || ack.ErrorCode != tt.code {
+			t.Fatalf("%s: ok=%v error_code=%q (%s), want code %q", requestID, ack.OK, ack.ErrorCode, ack.Error, tt.code)
+		}
+		if !ack.OK && ack.Error == "" {
+			t.Fatalf("%s: failed ack without error message", requestID)
+		}
+	}
+}
+
+func TestRegisterInstanceAckCarriesPreparedInstance(t *testing.T) {
+	_, peer := startTestRunner(t, &preparingHandler{}, RunnerConfig{})
+	sendCommand(peer, "register_instance", "r1", map[string]any{"id": "lamp", "name": "Lamp"})
+	if ev := peer.next(t, "sys/register"); ev.Data["id"] != "lamp" {
+		t.Fatalf("register event = %v", ev.Data)
+	}
+	ack := nextAck(t, peer, "r1")
+	inst, _ := ack.Result["instance"].(map[string]any)
+	if meta, _ := inst["meta"].(map[string]any); meta["prepared"] != true {
+		t.Fatalf("ack result = %v, want the prepared instance", ack.Result)
+	}
+}
+
+func TestInstancesResponseCarriesRequestID(t *testing.T) {
+	_, peer := startTestRunner(t, &scriptedHandler{}, RunnerConfig{})
+	sendCommand(peer, "get_instances", "r1", nil)
+	ev := peer.next(t, "sys/instances_response")
+	resp, err := DecodePayload[InstancesResponse](ev)
+	if err != nil || resp.Bundle != "mod" || resp.RequestID != "r1" {
+		t.Fatalf("response = %+v, %v", resp, err)
+	}
+}
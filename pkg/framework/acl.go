@@ -0,0 +1,50 @@
+package framework
+
+import "strings"
+
+// ACL lists the topic patterns a module may publish and subscribe to. A nil
+// list allows everything; an empty one allows nothing. Patterns use
+// subscription syntax, and "{module}" stands for the module's own ID.
+type ACL struct {
+	Publish   []string `json:"publish,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+}
+
+// Expand substitutes module for "{module}" in every pattern.
+func (a ACL) Expand(module string) ACL {
+	expand := func(patterns []string) []string {
+		if patterns == nil {
+			return nil
+		}
+		out := make([]string, len(patterns))
+		for i, p := range patterns {
+			out[i] = strings.ReplaceAll(p, "{module}", module)
+		}
+		return out
+	}
+	return ACL{Publish: expand(a.Publish), Subscribe: expand(a.Subscribe)}
+}
+
+// CanPublish reports whether topic may be published.
+func (a ACL) CanPublish(topic string) bool {
+	return aclAllows(a.Publish, topic)
+}
+
+// CanSubscribe reports whether events on topic may be received. topic may
+// itself be a subscription pattern, which is allowed if a single ACL pattern
+// covers it.
+func (a ACL) CanSubscribe(topic string) bool {
+	return aclAllows(a.Subscribe, topic)
+}
+
+func aclAllows(patterns []string, topic string) bool {
+	if patterns == nil {
+		return true
+	}
+	for _, p := range patterns {
+		if TopicMatches(p, topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,13 @@ package framework
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/metrics"
+	"github.com/lms-io/module-framework/pkg/units"
 )
 
 // ModuleAPI is the interface provided to the logic layer.
@@ -18,25 +23,71 @@ type ModuleAPI interface {
 	RegisterInstance(payload InstanceConfig) error
 	DeleteInstance(id string) error
 	UpdateEntityState(instanceID string, state map[string]map[string]any) error
+	// RegisterRawMapper derives abstract entities and their state from raw
+	// entities of rawKind. Register mappers before the instances using them.
+	RegisterRawMapper(rawKind string, mapper RawMapper)
+	// UpdateRawState stores raw state, keyed by raw entity ID, merged into
+	// the instance's raw state, then maps it to entity state and publishes
+	// it like UpdateEntityState.
+	UpdateRawState(instanceID string, state map[string]map[string]any) error
+	// MarkAvailable and MarkUnavailable set a registered instance online or
+	// offline on AvailabilityTopic. UpdateEntityState marks it available too,
+	// and it goes offline after the configured silence timeout without either.
+	MarkAvailable(instanceID string) error
+	MarkUnavailable(instanceID, reason string) error
+	Availability(instanceID string) InstanceAvailability
 	GetInstances() []InstanceConfig
 
 	// Communication
 	Publish(topic, eventType string, data map[string]any)
-	// Listen subscribes to any arbitrary topic (e.g. "commands/device-id", "state/*")
-	Listen(topic string) <-chan Event
+	// PublishContext publishes within the trace carried by ctx (see EventContext).
+	PublishContext(ctx context.Context, topic, eventType string, data map[string]any)
+	// PublishRetained publishes an event the broker keeps as this module's
+	// latest on topic, for subscribers using WithRetained. Nil data clears it
+	// and reaches live subscribers as an event of type ClearType.
+	PublishRetained(topic, eventType string, data map[string]any)
+	// Listen subscribes to any arbitrary topic (e.g. "commands/device-id", "state/*").
+	// Pass WithRetained to receive the last known events first, and ExcludeSelf
+	// or FromSources to filter by publishing module.
+	//
+	// Delivery stops when the subscription ends, through Unsubscribe or the
+	// end of the Init generation that created it. The channel is not closed;
+	// stop receiving when the context passed to Go is done.
+	Listen(topic string, opts ...SubscribeOption) <-chan Event
 	// Subscribe listens to state updates for a device, or a specific entity when provided.
+	// Use Listen("state/"+deviceID, WithRetained()) to start from the last known state.
 	Subscribe(deviceID string, entityID ...string) <-chan Event
+	// HandleDeviceCommand runs fn for commands of type cmdType sent to the
+	// entity on DeviceCommandTopic(instanceID), one command per device at a
+	// time, and publishes a CommandResult on DeviceResultTopic(instanceID). An
+	// empty entityID matches any entity. Commands for an entity with a Schema
+	// have their unit-bearing args converted to native units (see
+	// SetUnitSystem) and are checked against it before fn runs.
+	HandleDeviceCommand(instanceID, entityID, cmdType string, fn DeviceCommandFunc)
 	// Unsubscribe removes all listeners for a given topic.
 	Unsubscribe(topic string)
+	// Features reports what was negotiated with the bus broker at connect.
+	Features() Features
 
 	// Lifecycle
+	// Context is cancelled when the current Init generation ends (re-init or shutdown).
 	Context() context.Context
+	// Go runs fn in a goroutine tied to the current Init generation. The framework
+	// cancels ctx and waits for fn to return before re-initializing or stopping.
+	Go(fn func(ctx context.Context))
 
 	// Logging
+	// Info, Warn, Error and Debug format msg with args like fmt.Sprintf.
+	// Use Logger for structured key/value attributes.
 	Info(msg string, args ...any)
 	Warn(msg string, args ...any)
 	Error(msg string, args ...any)
 	Debug(msg string, args ...any)
+	Logger() *slog.Logger
+
+	// Metrics returns the module's registry so bundles can add their own metrics
+	// alongside the framework's. They are exposed through get_metrics and /metrics.
+	Metrics() *metrics.Registry
 }
 
 // BaseModule is the standard implementation of ModuleAPI.
@@ -47,81 +98,162 @@ type BaseModule struct {
 	im        *InstanceManager
 	ctx       context.Context
 	modConfig map[string]any
+	log       *slog.Logger
+	metrics   *metrics.Registry
+
+	mu      sync.Mutex
+	subIDs  map[string][]string // topic -> subIDs
+	gen     *generation
+	genSubs map[string]string        // subID -> topic, owned by the current generation
+	state   BundleState              // last published bundle state
+	routes  map[string]*deviceRoute  // instanceID -> device command route
+	mappers map[string]RawMapper     // raw entity kind -> mapper
+	units   units.System             // published state is converted to it
+	avail   map[string]*availability // instanceID -> availability
 
-	mu     sync.Mutex
-	subIDs map[string][]string // topic -> subIDs
+	silenceTimeout time.Duration // instances go offline after this long without an update
+
+	deviceMetrics *commandMetrics
 }
 
 func NewBaseModule(ctx context.Context, id, stateDir, busSocket string, config map[string]any) *BaseModule {
-	return &BaseModule{
+	m := &BaseModule{
 		id:        id,
 		stateDir:  stateDir,
 		bus:       NewBusClient(busSocket, id),
 		im:        NewInstanceManager(stateDir, id),
 		ctx:       ctx,
 		modConfig: config,
+		log:       slog.Default().With("module", id),
+		metrics:   metrics.NewRegistry(),
 		subIDs:    make(map[string][]string),
+		genSubs:   make(map[string]string),
+		routes:    make(map[string]*deviceRoute),
+		mappers:   make(map[string]RawMapper),
+		avail:     make(map[string]*availability),
+	}
+	m.deviceMetrics = newDeviceCommandMetrics(m.metrics)
+	m.bus.useMetrics(m.metrics)
+	m.im.useMetrics(m.metrics)
+	m.bus.onPanic = func(v any, stack []byte) {
+		m.reportCrash(&PanicError{Callback: "bus dispatch", Value: v, Stack: stack}, nil)
 	}
+	return m
 }
 
 func (m *BaseModule) Start() error {
-	return m.bus.Start()
+	m.updateWill()
+	if err := m.bus.Start(); err != nil {
+		return err
+	}
+	// Availability is not persisted; instances start unknown until heard from.
+	for _, inst := range m.GetInstances() {
+		m.registerAvailability(inst.ID)
+	}
+	return nil
 }
 
 func (m *BaseModule) ModuleID() string { return m.id }
 
+func (m *BaseModule) Features() Features { return m.bus.Features() }
+
 func (m *BaseModule) SetBundleStatus(status BundleStatus) {
-	m.bus.Publish("sys/bundle_status", "status", map[string]any{
-		"bundle":  m.id,
-		"state":   status.State,
-		"message": status.Message,
-		"config":  status.Config,
+	m.setBundleStatus(context.Background(), status)
+}
+
+func (m *BaseModule) setBundleStatus(ctx context.Context, status BundleStatus) {
+	m.mu.Lock()
+	m.state = status.State
+	m.mu.Unlock()
+	data, ok := m.encodeOrLog("sys/bundle_status", BundleStatusPayload{
+		Bundle:  m.id,
+		State:   status.State,
+		Message: status.Message,
+		Config:  status.Config,
 	})
+	if !ok {
+		// Still report the state; only the config could not be encoded.
+		data = mustEncode(BundleStatusPayload{Bundle: m.id, State: status.State, Message: status.Message})
+	}
+	m.bus.PublishRetainedContext(ctx, "sys/bundle_status", "status", data)
 }
 
 func (m *BaseModule) RegisterInstance(payload InstanceConfig) error {
+	return m.registerInstance(context.Background(), payload)
+}
+
+func (m *BaseModule) registerInstance(ctx context.Context, payload InstanceConfig) error {
+	m.deriveEntities(&payload)
+	if err := validateInstance(payload); err != nil {
+		return err
+	}
+	data, err := EncodePayload(RegisterPayload{Bundle: m.id, InstanceConfig: payload})
+	if err != nil {
+		return fmt.Errorf("instance %s: %w", payload.ID, err)
+	}
 	if err := m.im.RegisterInstance(payload); err != nil {
 		return err
 	}
-	m.bus.Publish("sys/register", "register", map[string]any{
-		"id":           payload.ID,
-		"name":         payload.Name,
-		"alias":        payload.Alias,
-		"bundle":       m.id,
-		"config":       payload.Config,
-		"raw_entities": payload.RawEntities,
-		"raw_state":    payload.RawState,
-		"entities":     payload.Entities,
-		"entity_state": payload.EntityState,
-		"meta":         payload.Meta,
-	})
+	m.bus.PublishContext(ctx, "sys/register", "register", data)
+	if payload.ID != "" {
+		m.registerAvailability(payload.ID)
+	}
+	m.updateWill()
 	return nil
 }
 
 func (m *BaseModule) DeleteInstance(id string) error {
+	return m.deleteInstance(context.Background(), id)
+}
+
+func (m *BaseModule) deleteInstance(ctx context.Context, id string) error {
+	var entityIDs []string
+	for _, inst := range m.GetInstances() {
+		if inst.ID == id {
+			for entityID := range inst.EntityState {
+				entityIDs = append(entityIDs, entityID)
+			}
+		}
+	}
 	if err := m.im.DeleteInstance(id); err != nil {
 		return err
 	}
-	m.bus.Publish("sys/unregister", "unregister", map[string]any{
-		"id":     id,
-		"bundle": m.id,
-	})
+	m.dropDeviceRoute(id)
+	m.forgetAvailability(id)
+	m.bus.PublishContext(ctx, "sys/unregister", "unregister", mustEncode(UnregisterPayload{ID: id, Bundle: m.id}))
+	m.updateWill()
+	// Clear the retained state so new subscribers don't see a deleted device.
+	m.bus.PublishRetainedContext(ctx, "state/"+id, ClearType, nil)
+	for _, entityID := range entityIDs {
+		m.bus.PublishRetainedContext(ctx, "state/"+id+"/"+entityID, ClearType, nil)
+	}
 	return nil
 }
 
 func (m *BaseModule) UpdateEntityState(id string, state map[string]map[string]any) error {
+	entities, _ := m.im.Entities(id)
+	if err := checkEntityState(entities, state); err != nil {
+		return fmt.Errorf("state update for %s rejected: %w", id, err)
+	}
+	// Encode everything before persisting, so a state that cannot be
+	// published is not stored either.
+	update := m.normalizeUnits(entities, StateUpdate{ID: id, EntityState: state})
+	data, err := EncodePayload(update)
+	if err != nil {
+		return fmt.Errorf("state update for %s: %w", id, err)
+	}
+	perEntity := make(map[string]map[string]any, len(state))
+	for entityID := range state {
+		if perEntity[entityID], err = EncodePayload(update.forEntity(entityID)); err != nil {
+			return fmt.Errorf("state update for %s: %w", id, err)
+		}
+	}
 	m.im.UpdateEntityState(id, state)
-	m.bus.Publish("state/"+id, "update", map[string]any{
-		"id":           id,
-		"entity_state": state,
-	})
-	for entityID, entityState := range state {
-		m.bus.Publish("state/"+id+"/"+entityID, "update", map[string]any{
-			"id":           id,
-			"entity_id":    entityID,
-			"entity_state": map[string]map[string]any{entityID: entityState},
-		})
+	m.bus.PublishRetained("state/"+id, "update", data)
+	for entityID, entityData := range perEntity {
+		m.bus.PublishRetained("state/"+id+"/"+entityID, "update", entityData)
 	}
+	m.MarkAvailable(id)
 	return nil
 }
 
@@ -130,17 +262,30 @@ func (m *BaseModule) GetInstances() []InstanceConfig {
 	return inst
 }
 
+// BundleState returns the state most recently set through SetBundleStatus.
+func (m *BaseModule) BundleState() BundleState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
 func (m *BaseModule) GetModuleConfig() map[string]any { return m.modConfig }
 
 func (m *BaseModule) Publish(topic, eventType string, data map[string]any) {
 	m.bus.Publish(topic, eventType, data)
 }
 
-func (m *BaseModule) Listen(topic string) <-chan Event {
-	ch, subID := m.bus.Subscribe(topic)
-	m.mu.Lock()
-	m.subIDs[topic] = append(m.subIDs[topic], subID)
-	m.mu.Unlock()
+func (m *BaseModule) PublishContext(ctx context.Context, topic, eventType string, data map[string]any) {
+	m.bus.PublishContext(ctx, topic, eventType, data)
+}
+
+func (m *BaseModule) PublishRetained(topic, eventType string, data map[string]any) {
+	m.bus.PublishRetained(topic, eventType, data)
+}
+
+func (m *BaseModule) Listen(topic string, opts ...SubscribeOption) <-chan Event {
+	ch, subID := m.bus.Subscribe(topic, opts...)
+	m.track(topic, subID)
 	return ch
 }
 
@@ -154,25 +299,90 @@ func (m *BaseModule) Subscribe(deviceID string, entityID ...string) <-chan Event
 		topic = "state/" + deviceID
 	}
 	ch, subID := m.bus.Subscribe(topic)
+	m.track(topic, subID)
+	return ch
+}
+
+func (m *BaseModule) track(topic, subID string) {
 	m.mu.Lock()
-	m.subIDs[topic] = append(m.subIDs[topic], subID)
+	m.trackLocked(topic, subID)
 	m.mu.Unlock()
-	return ch
+}
+
+func (m *BaseModule) trackLocked(topic, subID string) {
+	m.subIDs[topic] = append(m.subIDs[topic], subID)
+	if m.gen != nil {
+		m.genSubs[subID] = topic
+	}
+}
+
+// untrack forgets one subscription. The caller holds mu.
+func (m *BaseModule) untrack(topic, subID string) {
+	delete(m.genSubs, subID)
+	ids := m.subIDs[topic]
+	for i, id := range ids {
+		if id == subID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(m.subIDs, topic)
+	} else {
+		m.subIDs[topic] = ids
+	}
 }
 
 func (m *BaseModule) Unsubscribe(topic string) {
 	m.mu.Lock()
 	ids := m.subIDs[topic]
 	delete(m.subIDs, topic)
+	for _, subID := range ids {
+		delete(m.genSubs, subID)
+	}
 	m.mu.Unlock()
 	for _, subID := range ids {
 		m.bus.Unsubscribe(subID)
 	}
 }
 
-func (m *BaseModule) Context() context.Context { return m.ctx }
+func (m *BaseModule) Context() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gen != nil {
+		return m.gen.ctx
+	}
+	return m.ctx
+}
+
+func (m *BaseModule) Go(fn func(ctx context.Context)) {
+	m.mu.Lock()
+	gen := m.gen
+	if gen != nil {
+		gen.wg.Add(1) // under mu so endGeneration cannot start waiting first
+	}
+	m.mu.Unlock()
+	run := func(ctx context.Context) {
+		m.safeCall("Go", nil, func() error {
+			fn(ctx)
+			return nil
+		})
+	}
+	if gen == nil {
+		go run(m.ctx)
+		return
+	}
+	go func() {
+		defer gen.wg.Done()
+		run(gen.ctx)
+	}()
+}
+
+func (m *BaseModule) Info(msg string, args ...any)  { m.logf(slog.LevelInfo, msg, args...) }
+func (m *BaseModule) Warn(msg string, args ...any)  { m.logf(slog.LevelWarn, msg, args...) }
+func (m *BaseModule) Error(msg string, args ...any) { m.logf(slog.LevelError, msg, args...) }
+func (m *BaseModule) Debug(msg string, args ...any) { m.logf(slog.LevelDebug, msg, args...) }
+
+func (m *BaseModule) Logger() *slog.Logger { return m.log }
 
-func (m *BaseModule) Info(msg string, args ...any)  { log.Printf("INFO  ["+m.id+"] "+msg, args...) }
-func (m *BaseModule) Warn(msg string, args ...any)  { log.Printf("WARN  ["+m.id+"] "+msg, args...) }
-func (m *BaseModule) Error(msg string, args ...any) { log.Printf("ERROR ["+m.id+"] "+msg, args...) }
-func (m *BaseModule) Debug(msg string, args ...any) { log.Printf("DEBUG ["+m.id+"] "+msg, args...) }
+func (m *BaseModule) Metrics() *metrics.Registry { return m.metrics }
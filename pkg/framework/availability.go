@@ -0,0 +1,188 @@
+package framework
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultLastSeenInterval is how often LastSeen is republished when no
+// silence timeout is set.
+const defaultLastSeenInterval = time.Minute
+
+// Availability is whether an instance is reachable.
+type Availability string
+
+const (
+	AvailabilityUnknown Availability = "unknown" // Registered, not heard from yet
+	AvailabilityOnline  Availability = "online"
+	AvailabilityOffline Availability = "offline"
+)
+
+// AvailabilityTopic is the retained topic carrying an instance's availability.
+func AvailabilityTopic(instanceID string) string {
+	return "availability/" + instanceID
+}
+
+// InstanceAvailability is the availability of an instance, published as the
+// retained availability/<id> event whenever State or Reason changes, and
+// again when it is heard from, at most every half silence timeout (a minute
+// without one), to refresh LastSeen.
+type InstanceAvailability struct {
+	ID       string       `json:"id"`
+	Bundle   string       `json:"bundle"`
+	State    Availability `json:"state"`
+	LastSeen time.Time    `json:"last_seen,omitzero"` // last state update or MarkAvailable
+	Reason   string       `json:"reason,omitempty"`   // why it is offline
+}
+
+// availability is the tracked availability of one instance. Guarded by
+// BaseModule.mu.
+type availability struct {
+	InstanceAvailability
+	silence   *time.Timer
+	published time.Time // last publish of this entry
+}
+
+// SetAvailabilityTimeout marks an instance offline when it has had no state
+// update or MarkAvailable for d. Zero disables the timeout.
+func (m *BaseModule) SetAvailabilityTimeout(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.silenceTimeout = d
+}
+
+// MarkAvailable records that the instance was just heard from. It fails for
+// an instance that is not registered.
+func (m *BaseModule) MarkAvailable(instanceID string) error {
+	return m.setAvailability(instanceID, AvailabilityOnline, "", true)
+}
+
+// MarkUnavailable marks the instance offline with a reason, e.g.
+// "connection refused". It stays offline until the next MarkAvailable or
+// state update. It fails for an instance that is not registered.
+func (m *BaseModule) MarkUnavailable(instanceID, reason string) error {
+	return m.setAvailability(instanceID, AvailabilityOffline, reason, false)
+}
+
+// Availability returns the current availability of an instance.
+func (m *BaseModule) Availability(instanceID string) InstanceAvailability {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a := m.avail[instanceID]; a != nil {
+		return a.InstanceAvailability
+	}
+	return InstanceAvailability{ID: instanceID, Bundle: m.id, State: AvailabilityUnknown}
+}
+
+// setAvailability updates an instance and publishes the change. seen
+// refreshes LastSeen and restarts the silence timeout; the refreshed
+// LastSeen is published at most every lastSeenInterval.
+func (m *BaseModule) setAvailability(instanceID string, state Availability, reason string, seen bool) error {
+	if _, ok := m.im.Entities(instanceID); !ok {
+		return fmt.Errorf("availability for unknown instance %s", instanceID)
+	}
+	now := time.Now().UTC()
+	m.mu.Lock()
+	a := m.trackAvailability(instanceID)
+	publish := a.State != state || a.Reason != reason
+	a.State, a.Reason = state, reason
+	if seen {
+		a.LastSeen = now
+		publish = publish || now.Sub(a.published) >= m.lastSeenInterval()
+	}
+	if publish {
+		a.published = now
+	}
+	m.armSilence(instanceID, a, seen)
+	snapshot := a.InstanceAvailability
+	m.mu.Unlock()
+	if publish {
+		m.publishAvailability(snapshot)
+	}
+	return nil
+}
+
+// lastSeenInterval is the minimum time between publishes that only refresh
+// LastSeen. The caller holds mu.
+func (m *BaseModule) lastSeenInterval() time.Duration {
+	if m.silenceTimeout > 0 {
+		return m.silenceTimeout / 2
+	}
+	return defaultLastSeenInterval
+}
+
+// trackAvailability returns the entry of an instance, creating it as
+// unknown. The caller holds mu.
+func (m *BaseModule) trackAvailability(instanceID string) *availability {
+	a := m.avail[instanceID]
+	if a == nil {
+		a = &availability{InstanceAvailability: InstanceAvailability{ID: instanceID, Bundle: m.id, State: AvailabilityUnknown}}
+		m.avail[instanceID] = a
+	}
+	return a
+}
+
+// armSilence restarts the silence timer of an online instance and stops it
+// otherwise. The caller holds mu.
+func (m *BaseModule) armSilence(instanceID string, a *availability, seen bool) {
+	if a.silence != nil && (seen || a.State != AvailabilityOnline) {
+		a.silence.Stop()
+		a.silence = nil
+	}
+	if a.State != AvailabilityOnline || m.silenceTimeout <= 0 || a.silence != nil {
+		return
+	}
+	timeout := m.silenceTimeout
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		m.mu.Lock()
+		current := m.avail[instanceID] == a && a.silence == timer
+		m.mu.Unlock()
+		if current {
+			m.MarkUnavailable(instanceID, fmt.Sprintf("no update for %s", timeout))
+		}
+	})
+	a.silence = timer
+}
+
+// registerAvailability publishes unknown for an instance seen for the first
+// time.
+func (m *BaseModule) registerAvailability(instanceID string) {
+	m.mu.Lock()
+	_, known := m.avail[instanceID]
+	a := m.trackAvailability(instanceID)
+	if !known {
+		a.published = time.Now().UTC()
+	}
+	snapshot := a.InstanceAvailability
+	m.mu.Unlock()
+	if !known {
+		m.publishAvailability(snapshot)
+	}
+}
+
+// forgetAvailability stops tracking a deleted instance and clears its
+// retained event.
+func (m *BaseModule) forgetAvailability(instanceID string) {
+	m.mu.Lock()
+	if a := m.avail[instanceID]; a != nil && a.silence != nil {
+		a.silence.Stop()
+	}
+	delete(m.avail, instanceID)
+	m.mu.Unlock()
+	m.bus.PublishRetained(AvailabilityTopic(instanceID), ClearType, nil)
+}
+
+// markAllUnavailable takes every instance offline, e.g. when the module stops.
+func (m *BaseModule) markAllUnavailable(reason string) {
+	for _, inst := range m.GetInstances() {
+		m.MarkUnavailable(inst.ID, reason)
+	}
+}
+
+func (m *BaseModule) publishAvailability(a InstanceAvailability) {
+	topic := AvailabilityTopic(a.ID)
+	if data, ok := m.encodeOrLog(topic, a); ok {
+		m.bus.PublishRetained(topic, "availability", data)
+	}
+}
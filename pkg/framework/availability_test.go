@@ -0,0 +1,115 @@
+package framework
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAvailabilityFollowsUpdatesAndSilence(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	base.SetAvailabilityTimeout(50 * time.Millisecond)
+	if err := base.RegisterInstance(InstanceConfig{ID: "lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if a := base.Availability("lamp"); a.State != AvailabilityUnknown || !a.LastSeen.IsZero() {
+		t.Fatalf("after register = %+v", a)
+	}
+
+	if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": {"on": true}}); err != nil {
+		t.Fatal(err)
+	}
+	a := base.Availability("lamp")
+	if a.State != AvailabilityOnline || a.LastSeen.IsZero() {
+		t.Fatalf("after update = %+v", a)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for base.Availability("lamp").State == AvailabilityOnline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a := base.Availability("lamp"); a.State != AvailabilityOffline || a.Reason == "" {
+		t.Fatalf("after silence = %+v", a)
+	}
+
+	base.MarkAvailable("lamp")
+	base.MarkUnavailable("lamp", "connection refused")
+	if a := base.Availability("lamp"); a.State != AvailabilityOffline || a.Reason != "connection refused" {
+		t.Fatalf("after MarkUnavailable = %+v", a)
+	}
+
+	if err := base.DeleteInstance("lamp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := base.avail["lamp"]; ok {
+		t.Fatalf("deleted instance still tracked")
+	}
+}
+
+func TestAvailabilityRejectsUnknownInstances(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	if err := base.MarkAvailable("ghost"); err == nil {
+		t.Fatal("MarkAvailable accepted an unknown instance")
+	}
+	if err := base.MarkUnavailable("ghost", "gone"); err == nil {
+		t.Fatal("MarkUnavailable accepted an unknown instance")
+	}
+	if len(base.avail) != 0 {
+		t.Fatalf("unknown instance tracked: %v", base.avail)
+	}
+}
+
+func TestAvailabilityRepublishesLastSeen(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	peer := connectTestBus(t, base.bus)
+	base.SetAvailabilityTimeout(400 * time.Millisecond) // republish at most every 200ms
+	if err := base.RegisterInstance(InstanceConfig{ID: "lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	update := func() {
+		t.Helper()
+		if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": {"on": true}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastSeen := func(ev Event) time.Time {
+		a, err := DecodePayload[InstanceAvailability](ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.LastSeen
+	}
+
+	peer.nextWhere(t, AvailabilityTopic("lamp"), func(ev Event) bool { return ev.Data["state"] == "unknown" })
+	update()
+	first := lastSeen(peer.next(t, AvailabilityTopic("lamp")))
+	update() // within the interval: not republished
+	time.Sleep(210 * time.Millisecond)
+	update()
+	want := base.Availability("lamp").LastSeen
+	if got := lastSeen(peer.next(t, AvailabilityTopic("lamp"))); !got.Equal(want) || !got.After(first) {
+		t.Fatalf("republished last_seen %v, want the latest %v", got, want)
+	}
+}
+
+func TestDeleteInstanceCommandDropsAvailability(t *testing.T) {
+	r, peer := startTestRunner(t, &scriptedHandler{}, RunnerConfig{})
+	sendCommand(peer, "register_instance", "r1", map[string]any{"id": "lamp"})
+	nextAck(t, peer, "r1")
+	if err := r.base.MarkAvailable("lamp"); err != nil {
+		t.Fatal(err)
+	}
+	sendCommand(peer, "delete_instance", "r2", map[string]any{"id": "lamp"})
+	if ack := nextAck(t, peer, "r2"); !ack.OK {
+		t.Fatalf("ack = %+v", ack)
+	}
+	r.base.mu.Lock()
+	_, tracked := r.base.avail["lamp"]
+	r.base.mu.Unlock()
+	if tracked {
+		t.Fatal("deleted instance still tracked")
+	}
+	if err := r.base.MarkAvailable("lamp"); err == nil {
+		t.Fatal("MarkAvailable accepted a deleted instance")
+	}
+}
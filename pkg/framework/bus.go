@@ -1,94 +1,527 @@
 package framework
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/metrics"
+	"github.com/lms-io/module-framework/pkg/trace"
+)
+
+const (
+	outboundQueueSize       = 1024
+	defaultHandshakeTimeout = 2 * time.Second
 )
 
+// BusOptions tunes a BusClient connection. Zero values keep the defaults.
+type BusOptions struct {
+	// Framing is the preferred framing. FramingLength is requested from the
+	// broker in the handshake and falls back to FramingLine if it is not supported.
+	Framing string
+	// MaxFrameSize bounds frames in both directions (DefaultMaxFrameSize if 0).
+	MaxFrameSize int
+	// Codec is the preferred event encoding (CodecJSON if empty). Binary
+	// codecs are only offered together with FramingLength.
+	Codec string
+	// Token authenticates the module to the broker.
+	Token string
+	// HandshakeTimeout bounds the wait for the broker's welcome.
+	HandshakeTimeout time.Duration
+	// Legacy skips the handshake for brokers that predate it, so connecting
+	// does not wait for HandshakeTimeout. The connection uses line-framed
+	// JSON and no capabilities.
+	Legacy bool
+}
+
 // BusClient handles low-level communication with the system Unix socket.
 type BusClient struct {
 	socketPath string
 	id         string
 	conn       net.Conn
-	listeners  map[string]func(Event)
+	listeners  map[string]*subscription
 	mu         sync.Mutex
 	done       chan struct{}
+	closeOnce  sync.Once
 	seq        uint64
+	out        chan outFrame
+	onPanic    func(v any, stack []byte) // reports panics recovered during dispatch
+	metrics    *busMetrics
+	tracer     *trace.Tracer
+	recorder   *Recorder
+	opts       BusOptions
+	features   Features // negotiated, set before conn
+	codec      Codec
+	readDone   chan struct{}
+	readErr    error
+	will       []Event
+}
+
+// outFrame is a queued write. A frame with a flushed channel carries no data
+// and is closed by the writer once everything queued before it was written.
+type outFrame struct {
+	data    []byte
+	flushed chan struct{}
 }
 
 func NewBusClient(path, moduleID string) *BusClient {
 	return &BusClient{
 		socketPath: path,
 		id:         moduleID,
-		listeners:  make(map[string]func(Event)),
+		listeners:  make(map[string]*subscription),
 		done:       make(chan struct{}),
+		out:        make(chan outFrame, outboundQueueSize),
+		metrics:    newBusMetrics(metrics.NewRegistry()),
+		tracer:     trace.NewTracer(nil),
+		codec:      JSONCodec,
+		readDone:   make(chan struct{}),
 	}
 }
 
+// SetOptions configures the connection. Call it before Start.
+func (b *BusClient) SetOptions(opts BusOptions) {
+	b.opts = opts
+}
+
 func (b *BusClient) Start() error {
 	conn, err := net.Dial("unix", b.socketPath)
 	if err != nil {
 		return fmt.Errorf("failed to connect to bus socket: %v", err)
 	}
+	return b.StartConn(conn)
+}
+
+// StartConn starts the client on an established connection, such as the
+// in-process end returned by broker.Pipe.
+func (b *BusClient) StartConn(conn net.Conn) error {
+	fr := NewFrameReader(conn, FramingLine, b.opts.MaxFrameSize)
+	features, err := b.handshake(conn, fr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	codec, _ := CodecByName(features.Codec)
+	fr.SetMode(features.Framing)
+
+	b.mu.Lock()
+	b.features = features
+	b.codec = codec
 	b.conn = conn
+	b.mu.Unlock()
 
-	go b.readLoop()
+	go b.readLoop(fr, codec)
+	go b.writeLoop(conn)
 	return nil
 }
 
-func (b *BusClient) readLoop() {
-	scanner := bufio.NewScanner(b.conn)
-	for scanner.Scan() {
-		var ev Event
-		if err := json.Unmarshal(scanner.Bytes(), &ev); err == nil {
-			b.mu.Lock()
-			for _, l := range b.listeners {
-				go l(ev)
+// Features reports what was negotiated with the broker. It is only
+// meaningful after Start.
+func (b *BusClient) Features() Features {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.features
+}
+
+// Done is closed when the read loop stops, either after Close or because the
+// connection failed; Err then reports why.
+func (b *BusClient) Done() <-chan struct{} {
+	return b.readDone
+}
+
+// Err returns the error that ended the read loop, or nil after a clean Close.
+func (b *BusClient) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readErr
+}
+
+// writeLoop is the only writer on the connection, so frames are never interleaved.
+func (b *BusClient) writeLoop(conn net.Conn) {
+	for {
+		select {
+		case <-b.done:
+			return
+		case f := <-b.out:
+			if f.flushed != nil {
+				close(f.flushed)
+				continue
+			}
+			if _, err := conn.Write(f.data); err != nil {
+				slog.ErrorContext(busLogContext, "bus write failed", "module", b.id, "error", err)
+			}
+		}
+	}
+}
+
+func (b *BusClient) readLoop(fr *FrameReader, codec Codec) {
+	defer close(b.readDone)
+	for {
+		frame, err := fr.ReadFrame()
+		if errors.Is(err, ErrFrameTooLarge) {
+			slog.WarnContext(busLogContext, "dropped oversized bus frame", "module", b.id, "max_frame", fr.max)
+			b.metrics.dropped.Inc("", "oversize")
+			continue
+		}
+		if err != nil {
+			select {
+			case <-b.done:
+				// Closed by us; not an error.
+			default:
+				if errors.Is(err, io.EOF) {
+					err = fmt.Errorf("bus connection closed by peer")
+				}
+				slog.ErrorContext(busLogContext, "bus read loop stopped", "module", b.id, "error", err)
+				b.mu.Lock()
+				b.readErr = err
+				b.mu.Unlock()
 			}
-			b.mu.Unlock()
+			return
+		}
+		var ev Event
+		if err := codec.Unmarshal(frame, &ev); err != nil {
+			slog.WarnContext(busLogContext, "dropped malformed bus frame", "module", b.id, "error", err)
+			b.metrics.dropped.Inc("", "malformed")
+			continue
 		}
+		b.dispatch(ev)
 	}
 }
 
+// SetRecorder tees every inbound and outbound event to rec. Call it before Start.
+func (b *BusClient) SetRecorder(rec *Recorder) {
+	b.recorder = rec
+}
+
+func (b *BusClient) dispatch(ev Event) {
+	defer b.recoverDispatch()
+	b.metrics.received.Inc(topicPrefix(ev.Topic))
+	if b.recorder != nil {
+		b.recorder.Record(DirIn, ev)
+	}
+	if ev.Topic == RetainedTopic {
+		if ev.Type == "snapshot" {
+			b.handleSnapshot(ev)
+		}
+		return
+	}
+	// Deliver in the read loop, which never blocks on a full subscriber, so
+	// every subscription sees events in the order they arrived.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.listeners {
+		b.deliver(s, ev)
+	}
+}
+
+// deliver hands ev to one subscription; a panic there does not keep it from
+// the others.
+func (b *BusClient) deliver(s *subscription, ev Event) {
+	defer b.recoverDispatch()
+	s.deliver(ev)
+}
+
+// recoverDispatch keeps the read loop alive if delivering an event panics.
+// Only framework code runs here: bundles read their subscription channels in
+// goroutines started through Go, and safeCall recovers panics there.
+func (b *BusClient) recoverDispatch() {
+	if v := recover(); v != nil {
+		if b.onPanic != nil {
+			b.onPanic(v, debug.Stack())
+		} else {
+			slog.ErrorContext(busLogContext, "panic in bus dispatch", "module", b.id, "panic", v, "stack", string(debug.Stack()))
+		}
+	}
+}
+
+// Publish queues an event for the writer. Events published before Start or
+// after Close are dropped.
 func (b *BusClient) Publish(topic, eventType string, data map[string]any) {
-	ev := Event{Topic: topic, Type: eventType, Data: data}
-	payload, _ := json.Marshal(ev)
+	b.PublishContext(context.Background(), topic, eventType, data)
+}
+
+// PublishContext is Publish within the trace carried by ctx, if any: a publish
+// span is recorded and its context is attached to the event.
+func (b *BusClient) PublishContext(ctx context.Context, topic, eventType string, data map[string]any) {
+	b.publishContext(ctx, Event{Topic: topic, Type: eventType, Data: data})
+}
+
+func (b *BusClient) publishContext(ctx context.Context, ev Event) {
+	ev.Source = b.id
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, span := b.tracer.Start(ctx, "publish "+ev.Topic)
+		span.SetAttr("topic", ev.Topic)
+		span.SetAttr("type", ev.Type)
+		sc := span.SpanContext()
+		ev.TraceParent, ev.TraceState = sc.TraceParent(), sc.State
+		defer span.End()
+	}
+	b.publish(ev)
+}
+
+// PublishEvent queues ev as is, keeping its source, retain flag and trace
+// context, for tools that forward events recorded elsewhere. An empty source
+// is set to the module ID.
+func (b *BusClient) PublishEvent(ev Event) {
+	if ev.Source == "" {
+		ev.Source = b.id
+	}
+	b.publish(ev)
+}
+
+func (b *BusClient) publish(ev Event) {
+	b.send(ev, true)
+}
+
+// tryPublish is Publish without waiting for room in the outbound queue. It
+// reports whether the event was queued.
+func (b *BusClient) tryPublish(topic, eventType string, data map[string]any) bool {
+	return b.send(Event{Topic: topic, Type: eventType, Data: data, Source: b.id}, false)
+}
+
+// send encodes ev and queues it for the writer. If wait is false a full queue
+// drops the event instead of blocking.
+func (b *BusClient) send(ev Event, wait bool) bool {
 	b.mu.Lock()
-	if b.conn != nil {
-		fmt.Fprintf(b.conn, "%s\n", string(payload))
+	started := b.conn != nil
+	codec, features := b.codec, b.features
+	b.mu.Unlock()
+	prefix := topicPrefix(ev.Topic)
+	if !started {
+		b.metrics.dropped.Inc(prefix, "not_connected")
+		return false
 	}
+	if features.ACL != nil && !isControlTopic(ev.Topic) && !features.ACL.CanPublish(ev.Topic) {
+		slog.ErrorContext(busLogContext, "dropped bus publish not allowed by ACL", "module", b.id, "topic", ev.Topic)
+		b.metrics.dropped.Inc(prefix, "acl")
+		return false
+	}
+	payload, err := codec.Marshal(ev)
+	if err != nil {
+		slog.ErrorContext(busLogContext, "dropped bus publish", "module", b.id, "topic", ev.Topic, "error", err)
+		b.metrics.dropped.Inc(prefix, "encode")
+		return false
+	}
+	frame, err := AppendFrame(nil, features.Framing, payload, features.MaxFrame)
+	if err != nil {
+		slog.ErrorContext(busLogContext, "dropped bus publish", "module", b.id, "topic", ev.Topic, "error", err)
+		b.metrics.dropped.Inc(prefix, "oversize")
+		return false
+	}
+	if !wait {
+		if !b.tryEnqueue(outFrame{data: frame}) {
+			b.metrics.dropped.Inc(prefix, "queue_full")
+			return false
+		}
+	} else if !b.enqueue(outFrame{data: frame}) {
+		b.metrics.dropped.Inc(prefix, "closed")
+		return false
+	}
+	b.metrics.published.Inc(prefix)
+	if b.recorder != nil {
+		b.recorder.Record(DirOut, ev)
+	}
+	return true
+}
+
+func (b *BusClient) enqueue(f outFrame) bool {
+	select {
+	case b.out <- f:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+func (b *BusClient) tryEnqueue(f outFrame) bool {
+	select {
+	case <-b.done:
+		return false
+	default:
+	}
+	select {
+	case b.out <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush waits until every event published so far has been written.
+func (b *BusClient) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	started := b.conn != nil
 	b.mu.Unlock()
+	if !started {
+		return nil
+	}
+	f := outFrame{flushed: make(chan struct{})}
+	if !b.enqueue(f) {
+		return fmt.Errorf("bus closed")
+	}
+	select {
+	case <-f.flushed:
+		return nil
+	case <-b.done:
+		return fmt.Errorf("bus closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subscription is a single listener channel. Deliveries and stop are
+// serialized so no event is delivered once stop returns. The channel itself
+// is never closed: receivers that select on it without checking ok would
+// otherwise spin on zero events.
+type subscription struct {
+	topic   string
+	ch      chan Event
+	mu      sync.Mutex
+	closed  bool
+	dropped *metrics.Counter
+
+	self    string   // set when ExcludeSelf is used
+	sources []string // set when FromSources is used; empty keeps nothing
+
+	pending bool    // waiting for a retained snapshot
+	backlog []Event // live events held back while pending
 }
 
-func (b *BusClient) Subscribe(topic string) (<-chan Event, string) {
-	ch := make(chan Event, 100)
+// accepts applies the topic pattern and source filters.
+func (s *subscription) accepts(ev Event) bool {
+	if !TopicMatches(s.topic, ev.Topic) {
+		return false
+	}
+	if s.self != "" && ev.Source == s.self {
+		return false
+	}
+	return s.sources == nil || slices.Contains(s.sources, ev.Source)
+}
+
+func (s *subscription) deliver(ev Event) {
+	if !s.accepts(ev) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending {
+		if len(s.backlog) < maxBacklog {
+			s.backlog = append(s.backlog, ev)
+		} else {
+			s.dropped.Inc(topicPrefix(ev.Topic), "subscriber_full")
+		}
+		return
+	}
+	s.send(ev)
+}
+
+// hold queues live events until release.
+func (s *subscription) hold() {
+	s.mu.Lock()
+	s.pending = true
+	s.mu.Unlock()
+}
+
+// release delivers the retained events, then the held-back live events. Only
+// the first call after hold has an effect.
+func (s *subscription) release(retained []Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.pending {
+		return
+	}
+	s.pending = false
+	for _, ev := range retained {
+		if s.accepts(ev) {
+			s.send(ev)
+		}
+	}
+	for _, ev := range s.backlog {
+		s.send(ev)
+	}
+	s.backlog = nil
+}
+
+// send must be called with s.mu held.
+func (s *subscription) send(ev Event) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		// Buffer full, drop event
+		s.dropped.Inc(topicPrefix(ev.Topic), "subscriber_full")
+	}
+}
+
+func (s *subscription) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// QueueDepths reports the frames waiting to be written and the events
+// buffered across all subscription channels.
+func (b *BusClient) QueueDepths() (outbound, inbound int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.listeners {
+		inbound += len(s.ch)
+	}
+	return len(b.out), inbound
+}
+
+// Subscribe delivers events whose topic matches topic until Unsubscribe.
+func (b *BusClient) Subscribe(topic string, opts ...SubscribeOption) (<-chan Event, string) {
+	var o subscribeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &subscription{topic: topic, ch: make(chan Event, 100), dropped: b.metrics.dropped, sources: o.sources}
+	if o.excludeSelf {
+		s.self = b.id
+	}
 	b.mu.Lock()
 	b.seq++
 	subID := fmt.Sprintf("%d", b.seq)
-	b.listeners[subID] = func(ev Event) {
-		if topicMatches(topic, ev.Topic) {
-			select {
-			case ch <- ev:
-			default:
-				// Buffer full, drop event
-			}
-		}
-	}
+	b.listeners[subID] = s
+	acl := b.features.ACL
 	b.mu.Unlock()
-	return ch, subID
+	if acl != nil && !acl.CanSubscribe(topic) {
+		slog.WarnContext(busLogContext, "subscription not allowed by ACL, it will receive nothing", "module", b.id, "topic", topic)
+	}
+	if o.sources != nil && len(o.sources) == 0 {
+		slog.WarnContext(busLogContext, "subscription with an empty FromSources list, it will receive nothing", "module", b.id, "topic", topic)
+	}
+	if o.retained {
+		b.requestRetained(subID, s)
+	}
+	return s.ch, subID
 }
 
+// Unsubscribe removes the listener. No event is delivered to its channel
+// afterwards; the channel is not closed.
 func (b *BusClient) Unsubscribe(subID string) {
 	b.mu.Lock()
+	s := b.listeners[subID]
 	delete(b.listeners, subID)
 	b.mu.Unlock()
+	if s != nil {
+		s.stop()
+	}
 }
 
-func topicMatches(subscription, topic string) bool {
+// TopicMatches reports whether topic matches a subscription pattern, where a
+// trailing "*" matches any suffix.
+func TopicMatches(subscription, topic string) bool {
 	if strings.HasSuffix(subscription, "*") {
 		prefix := strings.TrimSuffix(subscription, "*")
 		return strings.HasPrefix(topic, prefix)
@@ -96,9 +529,18 @@ func topicMatches(subscription, topic string) bool {
 	return subscription == topic
 }
 
+// Close stops the writer and closes the connection. Call Flush first to avoid
+// dropping queued events. Close tells the broker the disconnect is clean, so
+// the will is not published. It is safe to call more than once.
 func (b *BusClient) Close() {
-	close(b.done)
-	if b.conn != nil {
-		b.conn.Close()
-	}
+	b.closeOnce.Do(func() {
+		b.sayBye()
+		close(b.done)
+		b.mu.Lock()
+		conn := b.conn
+		b.mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
 }
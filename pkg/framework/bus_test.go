@@ -1,6 +1,126 @@
 package framework
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPeer is the broker end of a client connection in tests. It answers the
+// handshake and collects everything the client publishes.
+type testPeer struct {
+	conn   net.Conn
+	caps   []string
+	events chan Event
+}
+
+// connectTestBus starts b on an in-memory connection to a new testPeer that
+// welcomes it with caps, or just CapWildcards if none are given.
+func connectTestBus(t *testing.T, b *BusClient, caps ...string) *testPeer {
+	t.Helper()
+	if len(caps) == 0 {
+		caps = []string{CapWildcards}
+	}
+	client, server := net.Pipe()
+	p := &testPeer{conn: server, caps: caps, events: make(chan Event, 1024)}
+	go p.serve()
+	if err := b.StartConn(client); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Close()
+		server.Close()
+	})
+	return p
+}
+
+func (p *testPeer) serve() {
+	fr := NewFrameReader(p.conn, FramingLine, 0)
+	for {
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			return
+		}
+		var ev Event
+		if json.Unmarshal(frame, &ev) != nil {
+			continue
+		}
+		if _, ok := ParseHello(ev); ok {
+			p.send(Welcome{Version: "test", Protocol: ProtocolVersion, Capabilities: p.caps, Framing: FramingLine, Codec: CodecJSON}.Event())
+			continue
+		}
+		select {
+		case p.events <- ev:
+		default: // the test is not reading; drop rather than stall the client
+		}
+	}
+}
+
+// send delivers ev to the client.
+func (p *testPeer) send(ev Event) {
+	data, _ := json.Marshal(ev)
+	p.conn.Write(append(data, '\n'))
+}
+
+// next returns the next event published on topic, skipping others.
+func (p *testPeer) next(t *testing.T, topic string) Event {
+	t.Helper()
+	return p.nextWhere(t, topic, func(Event) bool { return true })
+}
+
+// nextWhere returns the next event on topic for which match is true.
+func (p *testPeer) nextWhere(t *testing.T, topic string, match func(Event) bool) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-p.events:
+			if ev.Topic == topic && match(ev) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no matching event on %s", topic)
+		}
+	}
+}
+
+func TestDispatchKeepsArrivalOrder(t *testing.T) {
+	b := NewBusClient("", "mod")
+	ch, _ := b.Subscribe("commands/mod")
+	for i := range 50 {
+		b.dispatch(Event{Topic: "commands/mod", Type: "set", Data: map[string]any{"seq": i}})
+	}
+	for i := range 50 {
+		select {
+		case ev := <-ch:
+			if ev.Data["seq"] != i {
+				t.Fatalf("event %d has seq %v", i, ev.Data["seq"])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+}
+
+func TestEmptyFromSourcesWarns(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	b := NewBusClient("", "mod")
+
+	b.Subscribe("state/*", FromSources("hue"))
+	if buf.Len() != 0 {
+		t.Fatalf("warning for a non-empty source list: %s", buf.String())
+	}
+	b.Subscribe("state/*", FromSources())
+	if !strings.Contains(buf.String(), "empty FromSources") {
+		t.Fatalf("no warning for an empty source list: %q", buf.String())
+	}
+}
 
 func TestTopicMatches(t *testing.T) {
 	cases := []struct {
@@ -17,8 +137,8 @@ func TestTopicMatches(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		if got := topicMatches(tc.subscription, tc.topic); got != tc.want {
-			t.Fatalf("topicMatches(%q, %q)=%v want %v", tc.subscription, tc.topic, got, tc.want)
+		if got := TopicMatches(tc.subscription, tc.topic); got != tc.want {
+			t.Fatalf("TopicMatches(%q, %q)=%v want %v", tc.subscription, tc.topic, got, tc.want)
 		}
 	}
 }
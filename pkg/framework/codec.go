@@ -0,0 +1,502 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Codec names negotiated on a bus connection.
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+)
+
+// Codec encodes events on the bus. JSON is the default; binary codecs need
+// length-prefixed framing because their output may contain newlines.
+type Codec interface {
+	Name() string
+	Marshal(ev Event) ([]byte, error)
+	Unmarshal(data []byte, ev *Event) error
+}
+
+var (
+	// JSONCodec is the default codec. Numbers decode as float64 and byte
+	// slices as base64 strings.
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec is a compact MessagePack codec. Whole-number floats are
+	// sent as integers; integers decode as int64 (uint64 above
+	// math.MaxInt64) and byte slices stay []byte.
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// CodecByName returns the codec registered under name.
+func CodecByName(name string) (Codec, bool) {
+	switch name {
+	case CodecJSON, "":
+		return JSONCodec, true
+	case CodecMsgpack:
+		return MsgpackCodec, true
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                           { return CodecJSON }
+func (jsonCodec) Marshal(ev Event) ([]byte, error)       { return json.Marshal(ev) }
+func (jsonCodec) Unmarshal(data []byte, ev *Event) error { return json.Unmarshal(data, ev) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+// Marshal writes the event as a map keyed like its JSON form.
+func (msgpackCodec) Marshal(ev Event) ([]byte, error) {
+	n := 3
+	if ev.Source != "" {
+		n++
+	}
+	if ev.Retain {
+		n++
+	}
+	if ev.TraceParent != "" {
+		n++
+	}
+	if ev.TraceState != "" {
+		n++
+	}
+	e := &mpEncoder{buf: make([]byte, 0, 256)}
+	e.mapHeader(n)
+	e.str("topic")
+	e.str(ev.Topic)
+	e.str("type")
+	e.str(ev.Type)
+	e.str("data")
+	if err := e.value(ev.Data); err != nil {
+		return nil, err
+	}
+	if ev.Source != "" {
+		e.str("source")
+		e.str(ev.Source)
+	}
+	if ev.Retain {
+		e.str("retain")
+		e.buf = append(e.buf, 0xc3)
+	}
+	if ev.TraceParent != "" {
+		e.str("traceparent")
+		e.str(ev.TraceParent)
+	}
+	if ev.TraceState != "" {
+		e.str("tracestate")
+		e.str(ev.TraceState)
+	}
+	return e.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, ev *Event) error {
+	d := &mpDecoder{buf: data}
+	v, err := d.value()
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("msgpack: event is not a map")
+	}
+	*ev = Event{}
+	ev.Topic, _ = m["topic"].(string)
+	ev.Type, _ = m["type"].(string)
+	ev.Data, _ = m["data"].(map[string]any)
+	ev.Source, _ = m["source"].(string)
+	ev.Retain, _ = m["retain"].(bool)
+	ev.TraceParent, _ = m["traceparent"].(string)
+	ev.TraceState, _ = m["tracestate"].(string)
+	return nil
+}
+
+type mpEncoder struct {
+	buf []byte
+}
+
+func (e *mpEncoder) mapHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xde), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdf), uint32(n))
+	}
+}
+
+func (e *mpEncoder) arrayHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xdc), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdd), uint32(n))
+	}
+}
+
+func (e *mpEncoder) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *mpEncoder) bin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *mpEncoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *mpEncoder) uint(n uint64) {
+	switch {
+	case n < 128:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), n)
+	}
+}
+
+func (e *mpEncoder) value(v any) error {
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 0xc0)
+	case bool:
+		if v {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case string:
+		e.str(v)
+	case []byte:
+		e.bin(v)
+	case int:
+		e.int(int64(v))
+	case int8:
+		e.int(int64(v))
+	case int16:
+		e.int(int64(v))
+	case int32:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case uint:
+		e.uint(uint64(v))
+	case uint8:
+		e.uint(uint64(v))
+	case uint16:
+		e.uint(uint64(v))
+	case uint32:
+		e.uint(uint64(v))
+	case uint64:
+		e.uint(v)
+	case float32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xca), math.Float32bits(v))
+	case float64:
+		// JSON-shaped data holds every number as float64; whole numbers go
+		// out as the integers they were.
+		if v == math.Trunc(v) && math.Abs(v) <= maxExactFloat {
+			e.int(int64(v))
+		} else {
+			e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(v))
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			e.int(n)
+		} else if f, err := v.Float64(); err == nil {
+			return e.value(f)
+		} else {
+			e.str(v.String())
+		}
+	case map[string]any:
+		e.mapHeader(len(v))
+		for k, item := range v {
+			e.str(k)
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	case []any:
+		e.arrayHeader(len(v))
+		for _, item := range v {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	case []string:
+		e.arrayHeader(len(v))
+		for _, item := range v {
+			e.str(item)
+		}
+	case map[string]string:
+		e.mapHeader(len(v))
+		for k, item := range v {
+			e.str(k)
+			e.str(item)
+		}
+	default:
+		return e.generic(v)
+	}
+	return nil
+}
+
+// generic encodes structs, typed slices and maps through their JSON form,
+// so they look the same on the wire as with the JSON codec.
+func (e *mpEncoder) generic(v any) error {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var plain any
+	if err := dec.Decode(&plain); err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+	return e.value(plain)
+}
+
+type mpDecoder struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+// maxMsgpackDepth bounds how deeply arrays and maps may nest, so a hostile
+// frame cannot exhaust the stack.
+const maxMsgpackDepth = 1000
+
+var (
+	errShortMsgpack = errors.New("msgpack: unexpected end of data")
+	errDeepMsgpack  = fmt.Errorf("msgpack: nesting deeper than %d", maxMsgpackDepth)
+)
+
+// enter descends into an array or map; the caller defers leave.
+func (d *mpDecoder) enter() error {
+	if d.depth >= maxMsgpackDepth {
+		return errDeepMsgpack
+	}
+	d.depth++
+	return nil
+}
+
+func (d *mpDecoder) leave() { d.depth-- }
+
+func (d *mpDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.buf)-d.pos < n {
+		return nil, errShortMsgpack
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *mpDecoder) uintN(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *mpDecoder) value() (any, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.strOf(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Clone(raw), nil
+	case 0xca:
+		n, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uintN(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uintN(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0:
+		n, err := d.uintN(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.uintN(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.uintN(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.uintN(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.strOf(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type byte 0x%02x", c)
+}
+
+func (d *mpDecoder) strOf(n int) (string, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *mpDecoder) arrayOf(n int) ([]any, error) {
+	// Every element takes at least one byte; reject lengths the data cannot hold.
+	if n > len(d.buf)-d.pos {
+		return nil, errShortMsgpack
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	out := make([]any, n)
+	for i := range out {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (d *mpDecoder) mapOf(n int) (map[string]any, error) {
+	if 2*n > len(d.buf)-d.pos {
+		return nil, errShortMsgpack
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	out := make(map[string]any, n)
+	for range n {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key of type %T", k)
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package framework
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMsgpackCodecPreservesTypes(t *testing.T) {
+	ev := Event{
+		Topic:       "state/dev1",
+		Type:        "update",
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Data: map[string]any{
+			"brightness": 200,
+			"offset":     int64(-40000),
+			"big":        uint64(math.MaxUint64),
+			"temp":       21.5,
+			"setpoint":   20.0,
+			"on":         true,
+			"blob":       []byte{0, '\n', 0xff},
+			"tags":       []string{"a", "b"},
+			"nested":     map[string]any{"none": nil},
+			"status":     BundleStatus{State: StateReady},
+		},
+	}
+	data, err := MsgpackCodec.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := MsgpackCodec.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"brightness": int64(200),
+		"offset":     int64(-40000),
+		"big":        uint64(math.MaxUint64),
+		"temp":       21.5,
+		"setpoint":   int64(20),
+		"on":         true,
+		"blob":       []byte{0, '\n', 0xff},
+		"tags":       []any{"a", "b"},
+		"nested":     map[string]any{"none": nil},
+		"status":     map[string]any{"state": string(StateReady)},
+	}
+	if got.Topic != ev.Topic || got.Type != ev.Type || got.TraceParent != ev.TraceParent {
+		t.Fatalf("envelope = %+v", got)
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(got.Data[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, got.Data[k], v)
+		}
+	}
+}
+
+func TestMsgpackCodecRejectsTruncatedData(t *testing.T) {
+	data, _ := MsgpackCodec.Marshal(Event{Topic: "state/dev1", Data: map[string]any{"on": true}})
+	var ev Event
+	if err := MsgpackCodec.Unmarshal(data[:len(data)-1], &ev); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+}
+
+func TestMsgpackCodecRejectsDeepNesting(t *testing.T) {
+	// A map with one key whose value is an array nested far too deep.
+	frame := []byte{0x81, 0xa4, 'd', 'a', 't', 'a'}
+	frame = append(frame, bytes.Repeat([]byte{0x91}, 1<<20)...)
+	frame = append(frame, 0xc0)
+	var ev Event
+	err := MsgpackCodec.Unmarshal(frame, &ev)
+	if !errors.Is(err, errDeepMsgpack) {
+		t.Fatalf("err = %v, want a nesting error", err)
+	}
+
+	// Nesting within the limit still decodes.
+	frame = []byte{0x81, 0xa4, 'd', 'a', 't', 'a', 0x81, 0xa1, 'x'}
+	frame = append(frame, bytes.Repeat([]byte{0x91}, 100)...)
+	frame = append(frame, 0xc0)
+	if err := MsgpackCodec.Unmarshal(frame, &ev); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+}
+
+func benchmarkEvent() Event {
+	attrs := map[string]any{}
+	for i := range 8 {
+		attrs[fmt.Sprintf("sensor_%d", i)] = map[string]any{"value": 20 + i, "unit": "°C", "ok": true}
+	}
+	return Event{Topic: "state/living-room", Type: "update", Data: map[string]any{"entities": attrs, "seq": 123456}}
+}
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	ev := benchmarkEvent()
+	for _, c := range []Codec{JSONCodec, MsgpackCodec} {
+		b.Run(c.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			var size int
+			for b.Loop() {
+				data, err := c.Marshal(ev)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/event")
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	ev := benchmarkEvent()
+	for _, c := range []Codec{JSONCodec, MsgpackCodec} {
+		data, _ := c.Marshal(ev)
+		b.Run(c.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				var got Event
+				if err := c.Unmarshal(bytes.Clone(data), &got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package framework
 
+import "context"
+
 // BundleState represents the lifecycle phase of a module.
 type BundleState string
 
@@ -10,6 +12,8 @@ const (
 	StateStarting   BundleState = "starting"   // In the middle of initial discovery/sync
 	StateActive     BundleState = "active"     // Fully operational, initial sync complete
 	StateError      BundleState = "error"      // Config invalid or connection lost
+	StateStopping   BundleState = "stopping"   // Shutting down, no longer accepting commands
+	StateStopped    BundleState = "stopped"    // Shutdown complete, process about to exit
 )
 
 // BundleStatus provides human-readable context for the current state.
@@ -30,8 +34,11 @@ type EntitySpec struct {
 	ID           string         `json:"id"`
 	Kind         string         `json:"kind"` // abstract kind, e.g. "actuator", "sensor", "resource"
 	Name         string         `json:"name"`
+	Class        string         `json:"class,omitempty"`        // standard kind from pkg/kinds, e.g. "light"
+	DeviceClass  string         `json:"device_class,omitempty"` // what a sensor measures, e.g. "temperature"
 	Capabilities map[string]any `json:"capabilities,omitempty"`
-	Links        []string       `json:"links,omitempty"` // references to raw entities
+	Schema       *EntitySchema  `json:"schema,omitempty"` // typed attributes and commands, checked by the framework
+	Links        []string       `json:"links,omitempty"`  // references to raw entities
 }
 
 // InstanceConfig is the standardized container for any hardware device.
@@ -67,6 +74,13 @@ type InstanceLifecycleObserver interface {
 	OnInstanceDeleted(id string)
 }
 
+// ContextStopper is an optional interface for handlers that want a deadline
+// on shutdown. If implemented, StopContext is called instead of Stop and ctx
+// expires after RunnerConfig.StopTimeout.
+type ContextStopper interface {
+	StopContext(ctx context.Context) error
+}
+
 // DeviceDiscoverer is an optional interface that LifecycleHandlers can implement
 // to support on-demand single-device discovery via the "discover" command.
 type DeviceDiscoverer interface {
@@ -97,4 +111,15 @@ type Event struct {
 	Topic string         `json:"topic"` // e.g. "commands/device-id", "state/device-id"
 	Type  string         `json:"type"`  // e.g. "power", "refresh", "register"
 	Data  map[string]any `json:"data"`  // Payload
+
+	// Source is the ID of the publishing module. Brokers that authenticate
+	// connections overwrite it with the authenticated ID.
+	Source string `json:"source,omitempty"`
+	// Retain asks the broker to keep the event as the latest for its topic
+	// and publisher, for subscribers that join later.
+	Retain bool `json:"retain,omitempty"`
+
+	// W3C trace context of the span that published the event, if any.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
 }
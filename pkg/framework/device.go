@@ -0,0 +1,167 @@
+package framework
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// DeviceCommandFunc handles one command sent to an entity. args is the command
+// data without entity_id and request_id. Return a *CommandError to choose the
+// error code reported in the result.
+type DeviceCommandFunc func(ctx context.Context, args map[string]any) error
+
+// DeviceCommandTopic is the topic commands for an instance are sent on.
+func DeviceCommandTopic(instanceID string) string {
+	return "commands/" + instanceID
+}
+
+// DeviceResultTopic is the topic results of an instance's device commands are
+// published on. It is separate from ResponseTopic, so an instance ID can never
+// collide with a module ID.
+func DeviceResultTopic(instanceID string) string {
+	return "device_results/" + instanceID
+}
+
+// NewDeviceCommand builds a command for an entity of an instance. The result
+// is published on DeviceResultTopic(instanceID) with the same request ID.
+func NewDeviceCommand(instanceID, entityID, command, requestID string, args map[string]any) Event {
+	data := maps.Clone(args)
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["entity_id"] = entityID
+	data["request_id"] = requestID
+	return Event{Topic: DeviceCommandTopic(instanceID), Type: command, Data: data}
+}
+
+type commandKey struct{ entityID, command string }
+
+// deviceRoute owns the subscription to one instance's command topic. Its
+// worker runs commands one at a time, so handlers for a device never overlap.
+type deviceRoute struct {
+	gen      *generation // Init generation that registered it, nil if none
+	subID    string
+	done     chan struct{}                    // closed when the route is dropped
+	handlers map[commandKey]DeviceCommandFunc // guarded by BaseModule.mu
+}
+
+// HandleDeviceCommand registers fn for commands of type command sent to
+// entityID of the instance. An empty entityID handles the command for any
+// entity without a handler of its own. Registering again replaces fn.
+// Handlers registered during Init are dropped when the generation ends, and
+// all of an instance's handlers are dropped when it is deleted.
+func (m *BaseModule) HandleDeviceCommand(instanceID, entityID, command string, fn DeviceCommandFunc) {
+	key := commandKey{entityID, command}
+	if m.addDeviceHandler(instanceID, key, fn) {
+		return
+	}
+	topic := DeviceCommandTopic(instanceID)
+	ch, subID := m.bus.Subscribe(topic)
+	m.mu.Lock()
+	if r := m.routes[instanceID]; r != nil {
+		// Registered concurrently; keep the first subscription.
+		r.handlers[key] = fn
+		m.mu.Unlock()
+		m.bus.Unsubscribe(subID)
+		return
+	}
+	r := &deviceRoute{gen: m.gen, subID: subID, done: make(chan struct{}), handlers: map[commandKey]DeviceCommandFunc{key: fn}}
+	m.routes[instanceID] = r
+	m.trackLocked(topic, subID)
+	m.mu.Unlock()
+	m.Go(func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				return
+			case ev := <-ch:
+				m.runDeviceCommand(ctx, instanceID, r, ev)
+			}
+		}
+	})
+}
+
+// addDeviceHandler adds fn to an existing route and reports whether there was one.
+func (m *BaseModule) addDeviceHandler(instanceID string, key commandKey, fn DeviceCommandFunc) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.routes[instanceID]
+	if r != nil {
+		r.handlers[key] = fn
+	}
+	return r != nil
+}
+
+// runDeviceCommand dispatches ev to its handler and publishes the result.
+func (m *BaseModule) runDeviceCommand(ctx context.Context, instanceID string, r *deviceRoute, ev Event) {
+	args := maps.Clone(ev.Data)
+	if args == nil {
+		args = map[string]any{}
+	}
+	entityID := asString(args["entity_id"])
+	requestID := asString(args["request_id"])
+	delete(args, "entity_id")
+	delete(args, "request_id")
+
+	m.mu.Lock()
+	fn := r.handlers[commandKey{entityID, ev.Type}]
+	if fn == nil {
+		fn = r.handlers[commandKey{"", ev.Type}]
+	}
+	m.mu.Unlock()
+
+	start := time.Now()
+	ctx, span := m.bus.tracer.Start(EventContext(ctx, ev), "device command "+ev.Type)
+	span.SetAttr("instance", instanceID)
+	span.SetAttr("entity_id", entityID)
+	span.SetAttr("request_id", requestID)
+	var err error
+	if schema := m.entitySchema(instanceID, entityID); schema != nil {
+		m.nativeArgs(schema, ev.Type, args)
+		err = schema.CheckCommand(ev.Type, args)
+	}
+	if err == nil && fn == nil {
+		err = commandErrorf(ErrCodeUnsupported, "unsupported command %s for entity %q of %s", ev.Type, entityID, instanceID)
+	}
+	if err == nil {
+		err = m.safeCall("device command "+ev.Type, &ev, func() error { return fn(ctx, args) })
+	}
+
+	result := CommandResult{
+		Instance:  instanceID,
+		EntityID:  entityID,
+		Command:   ev.Type,
+		RequestID: requestID,
+		OK:        err == nil,
+	}
+	if err != nil {
+		m.log.Warn("device command failed", "instance", instanceID, "entity_id", entityID, "command", ev.Type, "request_id", requestID, "error", err)
+		result.ErrorCode = errorCode(err)
+		result.Error = err.Error()
+	}
+	topic := DeviceResultTopic(instanceID)
+	if data, ok := m.encodeOrLog(topic, result); ok {
+		m.bus.PublishContext(ctx, topic, "result", data)
+	}
+	span.RecordError(err)
+	span.End()
+	m.deviceMetrics.observe(ev.Type, start, err)
+}
+
+// dropDeviceRoute removes every handler of an instance and its subscription.
+func (m *BaseModule) dropDeviceRoute(instanceID string) {
+	m.mu.Lock()
+	r := m.routes[instanceID]
+	delete(m.routes, instanceID)
+	if r != nil {
+		m.untrack(DeviceCommandTopic(instanceID), r.subID)
+	}
+	m.mu.Unlock()
+	if r != nil {
+		m.bus.Unsubscribe(r.subID)
+		close(r.done)
+	}
+}
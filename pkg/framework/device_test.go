@@ -0,0 +1,71 @@
+package framework
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeviceCommandsRunSeriallyPerDevice(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	base.beginGeneration()
+
+	var running, overlaps atomic.Int32
+	calls := make(chan string, 10)
+	handler := func(name string) DeviceCommandFunc {
+		return func(ctx context.Context, args map[string]any) error {
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			calls <- name + ":" + asString(args["level"])
+			return nil
+		}
+	}
+	base.HandleDeviceCommand("lamp", "light", "set", handler("light"))
+	base.HandleDeviceCommand("lamp", "", "set", handler("any"))
+
+	base.bus.dispatch(NewDeviceCommand("lamp", "light", "set", "r1", map[string]any{"level": "a"}))
+	base.bus.dispatch(NewDeviceCommand("lamp", "fan", "set", "r2", map[string]any{"level": "b"}))
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case c := <-calls:
+			got[c] = true
+		case <-time.After(time.Second):
+			t.Fatalf("handlers not called, got %v", got)
+		}
+	}
+	if !got["light:a"] || !got["any:b"] {
+		t.Fatalf("dispatched %v, want light:a and any:b", got)
+	}
+	if overlaps.Load() != 0 {
+		t.Fatalf("commands for one device overlapped")
+	}
+
+	gen := base.endGeneration()
+	gen.wait(time.Second)
+	if len(base.routes) != 0 {
+		t.Fatalf("routes survived the generation: %v", base.routes)
+	}
+}
+
+func TestDeviceCommandResultsHaveTheirOwnTopic(t *testing.T) {
+	base := NewBaseModule(context.Background(), "lamp", t.TempDir(), "", nil)
+	peer := connectTestBus(t, base.bus)
+	base.HandleDeviceCommand("lamp", "", "set", func(context.Context, map[string]any) error { return nil })
+
+	peer.send(NewDeviceCommand("lamp", "light", "set", "r1", nil))
+	ev := peer.next(t, DeviceResultTopic("lamp"))
+	result, err := DecodePayload[CommandResult](ev)
+	if err != nil || !result.OK || result.RequestID != "r1" || result.EntityID != "light" {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+	// The module and the instance share an ID, yet the module's ack topic
+	// stays free of device results.
+	if DeviceResultTopic("lamp") == ResponseTopic("lamp") {
+		t.Fatal("device results share the module ack topic")
+	}
+}
@@ -0,0 +1,126 @@
+package framework
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Framing modes for bus connections. Connections start in FramingLine;
+// FramingLength is used only after both sides agree on it at connect time.
+const (
+	FramingLine   = "line"   // one JSON document per '\n'-terminated line
+	FramingLength = "length" // 4-byte big-endian length prefix, then the payload
+)
+
+// DefaultMaxFrameSize bounds a single frame unless configured otherwise.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is returned for a frame over the size limit. The oversized
+// frame has been skipped, so the reader can keep going.
+var ErrFrameTooLarge = errors.New("bus frame exceeds maximum size")
+
+// FrameReader splits a bus stream into frames.
+type FrameReader struct {
+	r       *bufio.Reader
+	mode    string
+	max     int
+	partial []byte // line bytes read before an interrupted ReadFrame
+	skip    bool   // discarding the rest of an oversized line
+}
+
+// NewFrameReader reads frames of at most max bytes (DefaultMaxFrameSize if max <= 0).
+func NewFrameReader(r io.Reader, mode string, max int) *FrameReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, 64<<10)
+	}
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	return &FrameReader{r: br, mode: mode, max: max}
+}
+
+// SetMode switches framing, keeping any bytes already buffered.
+func (f *FrameReader) SetMode(mode string) { f.mode = mode }
+
+// ReadFrame returns the next frame without its delimiter or prefix. The
+// returned slice is only valid until the next call.
+func (f *FrameReader) ReadFrame() ([]byte, error) {
+	if f.mode == FramingLength {
+		return f.readLength()
+	}
+	return f.readLine()
+}
+
+func (f *FrameReader) readLine() ([]byte, error) {
+	for {
+		chunk, err := f.r.ReadSlice('\n')
+		if f.skip {
+			if err == nil {
+				f.skip = false
+				return nil, ErrFrameTooLarge
+			}
+		} else {
+			f.partial = append(f.partial, chunk...)
+			if len(f.partial) > f.max+1 {
+				f.partial = f.partial[:0]
+				if err == nil {
+					return nil, ErrFrameTooLarge
+				}
+				f.skip = true
+			}
+		}
+		switch {
+		case err == nil:
+			line := f.partial[:len(f.partial)-1]
+			f.partial = f.partial[:0]
+			if len(line) == 0 {
+				continue // tolerate blank lines
+			}
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			// Keep partial data so a read interrupted by a deadline can resume.
+			return nil, err
+		}
+	}
+}
+
+func (f *FrameReader) readLength() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(f.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n > f.max {
+		if _, err := f.r.Discard(n); err != nil {
+			return nil, err
+		}
+		return nil, ErrFrameTooLarge
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// AppendFrame appends payload to dst framed for mode.
+func AppendFrame(dst []byte, mode string, payload []byte, max int) ([]byte, error) {
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	if len(payload) > max {
+		return dst, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, len(payload), max)
+	}
+	if mode == FramingLength {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+		return append(dst, payload...), nil
+	}
+	dst = append(dst, payload...)
+	return append(dst, '\n'), nil
+}
@@ -0,0 +1,40 @@
+package framework
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameReaderSkipsOversizedFrames(t *testing.T) {
+	for _, mode := range []string{FramingLine, FramingLength} {
+		t.Run(mode, func(t *testing.T) {
+			var stream []byte
+			for _, p := range []string{"first", strings.Repeat("x", 100), "last"} {
+				stream, _ = AppendFrame(stream, mode, []byte(p), 1000)
+			}
+			fr := NewFrameReader(bytes.NewReader(stream), mode, 64)
+
+			if f, err := fr.ReadFrame(); err != nil || string(f) != "first" {
+				t.Fatalf("first frame = %q, %v", f, err)
+			}
+			if _, err := fr.ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("oversized frame error = %v, want ErrFrameTooLarge", err)
+			}
+			if f, err := fr.ReadFrame(); err != nil || string(f) != "last" {
+				t.Fatalf("frame after oversize = %q, %v", f, err)
+			}
+			if _, err := fr.ReadFrame(); err != io.EOF {
+				t.Fatalf("end of stream = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestAppendFrameRejectsOversizedPayload(t *testing.T) {
+	if _, err := AppendFrame(nil, FramingLength, make([]byte, 65), 64); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("AppendFrame error = %v, want ErrFrameTooLarge", err)
+	}
+}
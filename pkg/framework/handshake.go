@@ -0,0 +1,350 @@
+package framework
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// FrameworkVersion is reported to the broker in the handshake.
+const FrameworkVersion = "0.2.0"
+
+// ProtocolVersion is the bus protocol spoken by this framework. Peers with a
+// different protocol version are rejected during the handshake.
+const ProtocolVersion = 1
+
+// Handshake topics. The hello and its answer are exchanged as line-framed
+// JSON before any other traffic. A broker that authenticates modules answers
+// the hello with an auth request first; only then does the client send its
+// token on AuthTopic, so a broker that fans the hello out never sees it.
+const (
+	HelloTopic   = "sys/hello"
+	WelcomeTopic = "sys/welcome"
+	AuthTopic    = "sys/auth"
+)
+
+// Capabilities a peer may advertise in the handshake.
+const (
+	CapWildcards    = "wildcards"     // "*" segments in subscription topics
+	CapRequestReply = "request_reply" // acks on responses/<module> carry the command's request_id
+	CapACL          = "acl"           // the broker enforces topic ACLs
+)
+
+// Capabilities offered by this framework.
+var clientCapabilities = []string{CapWildcards, CapRequestReply, CapACL, CapRetain, CapWill}
+
+// isControlTopic reports topics exchanged with the broker itself. They are
+// not fanned out, so topic ACLs do not apply to them.
+func isControlTopic(topic string) bool {
+	switch topic {
+	case HelloTopic, WelcomeTopic, AuthTopic, RetainedTopic, WillTopic, ByeTopic:
+		return true
+	}
+	return false
+}
+
+// ErrHandshakeRejected is returned by Start when the broker refuses the
+// connection; the wrapped message carries the broker's reason.
+var ErrHandshakeRejected = errors.New("bus handshake rejected")
+
+// Hello is the first message a client sends after connecting.
+type Hello struct {
+	Module       string
+	Version      string
+	Protocol     int
+	Capabilities []string
+	Framing      []string // in order of preference
+	Codecs       []string // in order of preference
+	MaxFrame     int
+	Will         []Event // published by the broker if the connection drops
+}
+
+func (h Hello) Event() Event {
+	return Event{Topic: HelloTopic, Type: "hello", Data: map[string]any{
+		"module":       h.Module,
+		"version":      h.Version,
+		"protocol":     h.Protocol,
+		"capabilities": h.Capabilities,
+		"framing":      h.Framing,
+		"codecs":       h.Codecs,
+		"max_frame":    h.MaxFrame,
+		"will":         encodeEvents(h.Will),
+	}}
+}
+
+// ParseHello reads a hello event; ok is false for any other event.
+func ParseHello(ev Event) (h Hello, ok bool) {
+	if ev.Topic != HelloTopic || ev.Type != "hello" {
+		return Hello{}, false
+	}
+	h.Module = asString(ev.Data["module"])
+	h.Version = asString(ev.Data["version"])
+	h.Protocol, _ = asInt(ev.Data["protocol"])
+	h.Capabilities = asStrings(ev.Data["capabilities"])
+	h.Framing = asStrings(ev.Data["framing"])
+	h.Codecs = asStrings(ev.Data["codecs"])
+	h.MaxFrame, _ = asInt(ev.Data["max_frame"])
+	h.Will = decodeEvents(ev.Data["will"])
+	return h, true
+}
+
+// AuthEvent carries the module's token. It is sent only after the broker
+// asked for it with a Welcome whose Auth is set.
+func AuthEvent(token string) Event {
+	return Event{Topic: AuthTopic, Type: "auth", Data: map[string]any{"token": token}}
+}
+
+// ParseAuth reads the token of an auth event; ok is false for any other event.
+func ParseAuth(ev Event) (token string, ok bool) {
+	if ev.Topic != AuthTopic || ev.Type != "auth" {
+		return "", false
+	}
+	return asString(ev.Data["token"]), true
+}
+
+// Welcome is the broker's answer to a Hello. A non-empty Error rejects the
+// connection; Auth asks for the token before the actual welcome.
+type Welcome struct {
+	Version      string
+	Protocol     int
+	Capabilities []string // supported by both sides
+	Framing      string
+	Codec        string
+	MaxFrame     int
+	ACL          *ACL // what the module may do; nil if unrestricted
+	Auth         bool
+	Error        string
+}
+
+func (w Welcome) Event() Event {
+	if w.Error != "" {
+		return Event{Topic: WelcomeTopic, Type: "reject", Data: map[string]any{
+			"protocol": w.Protocol,
+			"error":    w.Error,
+		}}
+	}
+	if w.Auth {
+		return Event{Topic: WelcomeTopic, Type: "auth", Data: map[string]any{"protocol": w.Protocol}}
+	}
+	data := map[string]any{
+		"version":      w.Version,
+		"protocol":     w.Protocol,
+		"capabilities": w.Capabilities,
+		"framing":      w.Framing,
+		"codec":        w.Codec,
+		"max_frame":    w.MaxFrame,
+	}
+	if w.ACL != nil {
+		data["acl"] = map[string]any{"publish": w.ACL.Publish, "subscribe": w.ACL.Subscribe}
+	}
+	return Event{Topic: WelcomeTopic, Type: "welcome", Data: data}
+}
+
+func parseWelcome(ev Event) Welcome {
+	w := Welcome{
+		Version:      asString(ev.Data["version"]),
+		Capabilities: asStrings(ev.Data["capabilities"]),
+		Framing:      asString(ev.Data["framing"]),
+		Codec:        asString(ev.Data["codec"]),
+		Auth:         ev.Type == "auth",
+		Error:        asString(ev.Data["error"]),
+	}
+	w.Protocol, _ = asInt(ev.Data["protocol"])
+	w.MaxFrame, _ = asInt(ev.Data["max_frame"])
+	if acl, ok := ev.Data["acl"].(map[string]any); ok {
+		w.ACL = &ACL{Publish: asStrings(acl["publish"]), Subscribe: asStrings(acl["subscribe"])}
+	}
+	if ev.Type == "reject" && w.Error == "" {
+		w.Error = "no reason given"
+	}
+	return w
+}
+
+// Features is the outcome of the handshake for one connection.
+type Features struct {
+	// Handshake is false when the broker predates the handshake; the
+	// connection then uses line-framed JSON and no capabilities are known.
+	Handshake     bool
+	BrokerVersion string
+	Protocol      int
+	Capabilities  []string
+	Framing       string
+	Codec         string
+	MaxFrame      int
+	// ACL is what the broker lets this module publish and subscribe to; nil
+	// if unrestricted. Publishes outside it are dropped before sending.
+	ACL *ACL
+}
+
+// Has reports whether both sides support capability.
+func (f Features) Has(capability string) bool {
+	return slices.Contains(f.Capabilities, capability)
+}
+
+func (b *BusClient) legacyFeatures() Features {
+	return Features{Framing: FramingLine, Codec: CodecJSON, MaxFrame: b.opts.MaxFrameSize}
+}
+
+// handshake sends a hello and waits for the welcome, sending the token when
+// the broker asks for it. A broker that does not know the handshake either
+// echoes the hello back or stays silent; both fall back to line-framed JSON,
+// a silent one only after HandshakeTimeout. With BusOptions.Legacy set no
+// hello is sent and the fallback is immediate.
+func (b *BusClient) handshake(conn net.Conn, fr *FrameReader) (Features, error) {
+	if b.opts.Legacy {
+		return b.legacyFeatures(), nil
+	}
+	hello := Hello{
+		Module:       b.id,
+		Version:      FrameworkVersion,
+		Protocol:     ProtocolVersion,
+		Capabilities: clientCapabilities,
+		Framing:      []string{FramingLine},
+		Codecs:       []string{CodecJSON},
+		MaxFrame:     b.opts.MaxFrameSize,
+		Will:         b.currentWill(),
+	}
+	if b.opts.Framing == FramingLength {
+		hello.Framing = []string{FramingLength, FramingLine}
+		// Binary codecs are only offered together with length framing.
+		if c, ok := CodecByName(b.opts.Codec); !ok {
+			slog.WarnContext(busLogContext, "unknown bus codec, using json", "module", b.id, "codec", b.opts.Codec)
+		} else if c != JSONCodec {
+			hello.Codecs = []string{c.Name(), CodecJSON}
+		}
+	} else if b.opts.Codec != "" && b.opts.Codec != CodecJSON {
+		slog.WarnContext(busLogContext, "bus codec requires length framing, using json", "module", b.id, "codec", b.opts.Codec)
+	}
+	req, _ := json.Marshal(hello.Event())
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return Features{}, err
+	}
+
+	timeout := b.opts.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		frame, err := fr.ReadFrame()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			slog.WarnContext(busLogContext, "bus did not answer the handshake, assuming a legacy broker; set BUS_LEGACY to skip the wait", "module", b.id, "waited", timeout)
+			return b.legacyFeatures(), nil
+		}
+		if errors.Is(err, ErrFrameTooLarge) {
+			continue
+		}
+		if err != nil {
+			return Features{}, err
+		}
+		var ev Event
+		if json.Unmarshal(frame, &ev) != nil {
+			continue
+		}
+		if h, ok := ParseHello(ev); ok && h.Module == b.id {
+			slog.DebugContext(busLogContext, "bus echoed the hello, assuming a legacy broker", "module", b.id)
+			return b.legacyFeatures(), nil
+		}
+		if ev.Topic != WelcomeTopic {
+			// Regular traffic from a broker that ignored the hello.
+			b.dispatch(ev)
+			continue
+		}
+		w := parseWelcome(ev)
+		if w.Error != "" {
+			return Features{}, fmt.Errorf("%w: %s", ErrHandshakeRejected, w.Error)
+		}
+		if w.Auth {
+			auth, _ := json.Marshal(AuthEvent(b.opts.Token))
+			if _, err := conn.Write(append(auth, '\n')); err != nil {
+				return Features{}, err
+			}
+			continue
+		}
+		if w.Protocol != ProtocolVersion {
+			return Features{}, fmt.Errorf("incompatible bus protocol %d (broker %s), this module speaks %d",
+				w.Protocol, w.Version, ProtocolVersion)
+		}
+		f := Features{
+			Handshake:     true,
+			BrokerVersion: w.Version,
+			Protocol:      w.Protocol,
+			Capabilities:  w.Capabilities,
+			Framing:       FramingLine,
+			Codec:         CodecJSON,
+			MaxFrame:      b.opts.MaxFrameSize,
+			ACL:           w.ACL,
+		}
+		if w.Framing == FramingLength && slices.Contains(hello.Framing, FramingLength) {
+			f.Framing = FramingLength
+			if slices.Contains(hello.Codecs, w.Codec) {
+				f.Codec = w.Codec
+			}
+		}
+		if w.MaxFrame > 0 && (f.MaxFrame <= 0 || w.MaxFrame < f.MaxFrame) {
+			f.MaxFrame = w.MaxFrame
+		}
+		return f, nil
+	}
+}
+
+// encodeEvents turns events into plain values so they can travel inside
+// another event's data with any codec.
+func encodeEvents(events []Event) []any {
+	list := make([]any, len(events))
+	for i, ev := range events {
+		m := map[string]any{
+			"topic":  ev.Topic,
+			"type":   ev.Type,
+			"data":   ev.Data,
+			"source": ev.Source,
+		}
+		if ev.Retain {
+			m["retain"] = true
+		}
+		list[i] = m
+	}
+	return list
+}
+
+func decodeEvents(v any) []Event {
+	list, _ := v.([]any)
+	var events []Event
+	for _, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		data, _ := m["data"].(map[string]any)
+		events = append(events, Event{
+			Topic:  asString(m["topic"]),
+			Type:   asString(m["type"]),
+			Data:   data,
+			Source: asString(m["source"]),
+			Retain: asBool(m["retain"], false),
+		})
+	}
+	return events
+}
+
+func asStrings(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLegacyOptionSkipsHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	frames := make(chan Event, 10)
+	go func() { // a broker that never answers a hello
+		fr := NewFrameReader(server, FramingLine, 0)
+		for {
+			frame, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			var ev Event
+			json.Unmarshal(frame, &ev)
+			frames <- ev
+		}
+	}()
+
+	b := NewBusClient("", "mod")
+	b.SetOptions(BusOptions{Legacy: true, HandshakeTimeout: time.Minute})
+	start := time.Now()
+	if err := b.StartConn(client); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("StartConn took %v", elapsed)
+	}
+	if f := b.Features(); f.Handshake || f.Framing != FramingLine || f.Codec != CodecJSON {
+		t.Fatalf("features = %+v, want legacy line/JSON", f)
+	}
+
+	b.Publish("state/lamp", "update", map[string]any{"on": true})
+	select {
+	case ev := <-frames:
+		if ev.Topic != "state/lamp" {
+			t.Fatalf("first frame on %s, want no hello before regular traffic", ev.Topic)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish not written")
+	}
+}
+
+func TestHandshakeKeepsTokenFromLegacyBroker(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	frames := make(chan []byte, 10)
+	go func() { // a broker that fans every line out, the hello included
+		fr := NewFrameReader(server, FramingLine, 0)
+		for {
+			frame, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			line := bytes.Clone(frame)
+			frames <- line
+			server.Write(append(line, '\n'))
+		}
+	}()
+
+	b := NewBusClient("", "mod")
+	b.SetOptions(BusOptions{Token: "s3cret"})
+	if err := b.StartConn(client); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	if b.Features().Handshake {
+		t.Fatal("handshake with a broker that echoed the hello")
+	}
+	b.Publish("state/lamp", "update", nil)
+	for {
+		select {
+		case frame := <-frames:
+			if bytes.Contains(frame, []byte("s3cret")) {
+				t.Fatalf("token sent to a legacy broker: %s", frame)
+			}
+			var ev Event
+			if json.Unmarshal(frame, &ev) == nil && ev.Topic == "state/lamp" {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("publish not written")
+		}
+	}
+}
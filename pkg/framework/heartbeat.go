@@ -0,0 +1,129 @@
+package framework
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// commandTracker records what the command loop is doing for liveness reports.
+type commandTracker struct {
+	mu       sync.Mutex
+	current  *Event
+	since    time.Time
+	reported bool // watchdog already fired for the current command
+	last     *Event
+	lastAt   time.Time
+}
+
+func (t *commandTracker) begin(ev Event) {
+	t.mu.Lock()
+	t.current = &ev
+	t.since = time.Now()
+	t.reported = false
+	t.mu.Unlock()
+}
+
+func (t *commandTracker) end() {
+	t.mu.Lock()
+	t.last = t.current
+	t.lastAt = time.Now()
+	t.current = nil
+	t.mu.Unlock()
+}
+
+// stuck returns the running command if it exceeded timeout and has not been
+// reported yet, marking it reported.
+func (t *commandTracker) stuck(timeout time.Duration) (*Event, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == nil || t.reported {
+		return nil, 0
+	}
+	running := time.Since(t.since)
+	if running < timeout {
+		return nil, 0
+	}
+	t.reported = true
+	return t.current, running
+}
+
+func (t *commandTracker) snapshot() map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := map[string]any{}
+	if t.last != nil {
+		out["last_command"] = map[string]any{
+			"type":       t.last.Type,
+			"request_id": asString(t.last.Data["request_id"]),
+			"at":         t.lastAt.UTC().Format(time.RFC3339),
+		}
+	}
+	if t.current != nil {
+		out["current_command"] = map[string]any{
+			"type":       t.current.Type,
+			"request_id": asString(t.current.Data["request_id"]),
+			"since":      t.since.UTC().Format(time.RFC3339),
+		}
+		out["stuck"] = t.reported
+	} else {
+		out["stuck"] = false
+	}
+	return out
+}
+
+func (r *runner) heartbeatLoop() {
+	if r.cfg.HeartbeatInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.liveDone:
+			return
+		case <-ticker.C:
+			r.base.Publish("sys/heartbeat", "heartbeat", r.heartbeat())
+		}
+	}
+}
+
+func (r *runner) heartbeat() map[string]any {
+	outbound, inbound := r.base.bus.QueueDepths()
+	data := r.tracker.snapshot()
+	data["bundle"] = r.cfg.ModuleID
+	data["state"] = r.base.BundleState()
+	data["uptime_s"] = int64(time.Since(r.started).Seconds())
+	data["goroutines"] = runtime.NumGoroutine()
+	data["instances"] = r.base.im.Count()
+	data["queue"] = map[string]any{"outbound": outbound, "inbound": inbound}
+	return data
+}
+
+// watchdogLoop reports a command that has been running longer than
+// WatchdogTimeout, once per command.
+func (r *runner) watchdogLoop() {
+	if r.cfg.WatchdogTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(r.cfg.WatchdogTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.liveDone:
+			return
+		case <-ticker.C:
+			ev, running := r.tracker.stuck(r.cfg.WatchdogTimeout)
+			if ev == nil {
+				continue
+			}
+			r.base.log.Warn("command loop stuck", "command", ev.Type, "request_id", asString(ev.Data["request_id"]), "running", running.Round(time.Second))
+			r.base.Publish("sys/watchdog", "stuck", map[string]any{
+				"bundle":     r.cfg.ModuleID,
+				"command":    ev.Type,
+				"request_id": asString(ev.Data["request_id"]),
+				"running_s":  int64(running.Seconds()),
+			})
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package framework
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatReportsLiveness(t *testing.T) {
+	_, peer := startTestRunner(t, &scriptedHandler{}, RunnerConfig{HeartbeatInterval: 20 * time.Millisecond})
+	sendCommand(peer, "register_instance", "r1", map[string]any{"id": "lamp"})
+	if ack := nextAck(t, peer, "r1"); !ack.OK {
+		t.Fatalf("register ack = %+v", ack)
+	}
+
+	ev := peer.nextWhere(t, "sys/heartbeat", func(ev Event) bool { return ev.Data["last_command"] != nil })
+	if ev.Data["bundle"] != "mod" || ev.Data["state"] != string(StateIdling) || ev.Data["instances"] != float64(1) {
+		t.Fatalf("heartbeat = %v", ev.Data)
+	}
+	if last, _ := ev.Data["last_command"].(map[string]any); last["type"] != "register_instance" || last["request_id"] != "r1" {
+		t.Fatalf("last_command = %v", ev.Data["last_command"])
+	}
+	if ev.Data["stuck"] != false || ev.Data["current_command"] != nil {
+		t.Fatalf("idle heartbeat reports a running command: %v", ev.Data)
+	}
+	for _, key := range []string{"uptime_s", "goroutines"} {
+		if _, ok := ev.Data[key].(float64); !ok {
+			t.Fatalf("heartbeat without %s: %v", key, ev.Data)
+		}
+	}
+	if queue, _ := ev.Data["queue"].(map[string]any); queue["outbound"] == nil || queue["inbound"] == nil {
+		t.Fatalf("queue = %v", ev.Data["queue"])
+	}
+}
+
+func TestWatchdogReportsStuckCommand(t *testing.T) {
+	release := make(chan struct{})
+	handler := &scriptedHandler{validate: func(context.Context, map[string]any) error {
+		<-release
+		return nil
+	}}
+	_, peer := startTestRunner(t, handler, RunnerConfig{WatchdogTimeout: 40 * time.Millisecond, HeartbeatInterval: 20 * time.Millisecond})
+	sendCommand(peer, "set_config", "r1", map[string]any{"config": map[string]any{}})
+
+	ev := peer.next(t, "sys/watchdog")
+	if ev.Type != "stuck" || ev.Data["command"] != "set_config" || ev.Data["request_id"] != "r1" {
+		t.Fatalf("watchdog = %v", ev.Data)
+	}
+	hb := peer.nextWhere(t, "sys/heartbeat", func(ev Event) bool { return ev.Data["stuck"] == true })
+	if cur, _ := hb.Data["current_command"].(map[string]any); cur["request_id"] != "r1" {
+		t.Fatalf("current_command = %v", hb.Data["current_command"])
+	}
+	close(release)
+	if ack := nextAck(t, peer, "r1"); !ack.OK {
+		t.Fatalf("ack = %+v", ack)
+	}
+}
+
+func TestWatchdogCoversStartupInit(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	handler := &scriptedHandler{init: func(ModuleAPI) error {
+		<-release
+		return nil
+	}}
+	_, peer := startTestRunner(t, handler, RunnerConfig{WatchdogTimeout: 40 * time.Millisecond})
+	if ev := peer.next(t, "sys/watchdog"); ev.Data["command"] != "startup" {
+		t.Fatalf("watchdog = %v", ev.Data)
+	}
+}
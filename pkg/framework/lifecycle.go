@@ -0,0 +1,175 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errInitInProgress = errors.New("init already in progress")
+	errStopRunning    = errors.New("previous Stop is still running")
+)
+
+// generation holds the resources owned by a single Init call so they can be
+// released before the next one.
+type generation struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// wait blocks until every goroutine started through Go has returned or the
+// timeout elapses. It reports whether the generation fully drained.
+func (g *generation) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// beginGeneration starts a fresh child context for the next Init call.
+func (m *BaseModule) beginGeneration() {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.mu.Lock()
+	m.gen = &generation{ctx: ctx, cancel: cancel}
+	m.mu.Unlock()
+}
+
+// endGeneration cancels the current generation and ends every subscription
+// created during it. The caller is responsible for waiting on the result.
+func (m *BaseModule) endGeneration() *generation {
+	m.mu.Lock()
+	gen := m.gen
+	m.gen = nil
+	subs := m.genSubs
+	m.genSubs = make(map[string]string)
+	for subID, topic := range subs {
+		m.untrack(topic, subID)
+	}
+	for id, r := range m.routes {
+		if r.gen == gen && gen != nil {
+			delete(m.routes, id)
+		}
+	}
+	m.mu.Unlock()
+
+	if gen != nil {
+		gen.cancel()
+	}
+	for subID := range subs {
+		m.bus.Unsubscribe(subID)
+	}
+	return gen
+}
+
+// lifecycle serializes Init/Stop calls on a handler and tears the previous
+// generation down before every re-initialization.
+type lifecycle struct {
+	base        *BaseModule
+	handler     LifecycleHandler
+	stopTimeout time.Duration
+
+	mu      sync.Mutex
+	started bool // handler.Init was called and Stop is still owed
+	// stopping receives the result of a Stop that timed out. Init waits for
+	// it, so the handler is never initialized while its old Stop still runs.
+	stopping <-chan error
+}
+
+func newLifecycle(base *BaseModule, handler LifecycleHandler, stopTimeout time.Duration) *lifecycle {
+	return &lifecycle{base: base, handler: handler, stopTimeout: stopTimeout}
+}
+
+// Init stops the previous generation, if any, and calls handler.Init in a new one.
+// Concurrent calls are rejected rather than queued. cmd is the triggering
+// command, or nil for the startup Init.
+func (l *lifecycle) Init(cmd *Event) error {
+	if !l.mu.TryLock() {
+		return errInitInProgress
+	}
+	defer l.mu.Unlock()
+	if err := l.teardown(); err != nil {
+		l.base.log.Error("Stop failed", "error", err)
+	}
+	if err := l.awaitStop(); err != nil {
+		return err
+	}
+	l.base.beginGeneration()
+	l.started = true
+	return l.base.safeCall("Init", cmd, func() error { return l.handler.Init(l.base) })
+}
+
+// Stop tears down the current generation. It is safe to call more than once.
+func (l *lifecycle) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.teardown()
+}
+
+func (l *lifecycle) teardown() error {
+	if !l.started {
+		return nil
+	}
+	l.started = false
+	gen := l.base.endGeneration()
+
+	stop := l.handler.Stop
+	if cs, ok := l.handler.(ContextStopper); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), l.stopTimeout)
+		defer cancel()
+		stop = func() error { return cs.StopContext(ctx) }
+	}
+	result := make(chan error, 1)
+	go func() { result <- l.base.safeCall("Stop", nil, stop) }()
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(l.stopTimeout):
+		err = fmt.Errorf("timed out after %s", l.stopTimeout)
+		l.stopping = result
+	}
+	if gen != nil && !gen.wait(l.stopTimeout) {
+		l.base.log.Warn("goroutines still running after stop", "timeout", l.stopTimeout)
+	}
+	return err
+}
+
+// awaitStop gives a Stop that timed out one more stopTimeout to return. If it
+// still runs, Init is refused rather than overlapping with it.
+func (l *lifecycle) awaitStop() error {
+	if l.stopping == nil {
+		return nil
+	}
+	select {
+	case err := <-l.stopping:
+		l.stopping = nil
+		if err != nil {
+			l.base.log.Warn("late Stop failed", "error", err)
+		}
+		return nil
+	case <-time.After(l.stopTimeout):
+		return errStopRunning
+	}
+}
+
+// callWithTimeout runs fn and gives up waiting for it after timeout.
+func callWithTimeout(timeout time.Duration, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
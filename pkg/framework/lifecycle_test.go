@@ -0,0 +1,183 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingHandler struct {
+	inits, stops int
+	ctxs         []context.Context
+	chans        []<-chan Event
+}
+
+func (h *countingHandler) ValidateConfig(context.Context, map[string]any) error { return nil }
+
+func (h *countingHandler) Init(api ModuleAPI) error {
+	h.inits++
+	h.ctxs = append(h.ctxs, api.Context())
+	h.chans = append(h.chans, api.Listen("state/*"))
+	api.Go(func(ctx context.Context) { <-ctx.Done() })
+	return nil
+}
+
+func (h *countingHandler) Stop() error {
+	h.stops++
+	return nil
+}
+
+// deliveredWithin reports the event received from ch within d, if any.
+func deliveredWithin(ch <-chan Event, d time.Duration) (Event, bool) {
+	select {
+	case ev := <-ch:
+		return ev, true
+	case <-time.After(d):
+		return Event{}, false
+	}
+}
+
+func TestLifecycleReinitTearsDownPreviousGeneration(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	h := &countingHandler{}
+	lc := newLifecycle(base, h, time.Second)
+
+	if err := lc.Init(nil); err != nil {
+		t.Fatalf("first Init: %v", err)
+	}
+	if err := lc.Init(nil); err != nil {
+		t.Fatalf("second Init: %v", err)
+	}
+	if h.inits != 2 || h.stops != 1 {
+		t.Fatalf("inits=%d stops=%d want 2/1", h.inits, h.stops)
+	}
+	if h.ctxs[0].Err() == nil {
+		t.Fatalf("first generation context not cancelled")
+	}
+	base.bus.dispatch(Event{Topic: "state/lamp", Type: "update"})
+	if ev, ok := deliveredWithin(h.chans[0], 50*time.Millisecond); ok {
+		t.Fatalf("first generation subscription still delivers %+v", ev)
+	}
+	if _, ok := deliveredWithin(h.chans[1], time.Second); !ok {
+		t.Fatalf("current generation subscription did not deliver")
+	}
+	if h.ctxs[1].Err() != nil {
+		t.Fatalf("current generation context cancelled")
+	}
+
+	lc.Stop()
+	lc.Stop()
+	if h.stops != 2 {
+		t.Fatalf("stops=%d want 2", h.stops)
+	}
+	base.bus.dispatch(Event{Topic: "state/lamp", Type: "update"})
+	if ev, ok := deliveredWithin(h.chans[1], 50*time.Millisecond); ok {
+		t.Fatalf("second generation subscription still delivers %+v", ev)
+	}
+}
+
+// selectHandler consumes its subscription the way bundles written against
+// earlier releases do: a select on the channel without checking ok.
+type selectHandler struct {
+	events, exits atomic.Int32
+}
+
+func (h *selectHandler) ValidateConfig(context.Context, map[string]any) error { return nil }
+
+func (h *selectHandler) Init(api ModuleAPI) error {
+	ch := api.Listen("state/*")
+	api.Go(func(ctx context.Context) {
+		defer h.exits.Add(1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				h.events.Add(1)
+			}
+		}
+	})
+	return nil
+}
+
+func (h *selectHandler) Stop() error { return nil }
+
+func TestLifecycleEndsSelectConsumerWithoutSpinning(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	h := &selectHandler{}
+	lc := newLifecycle(base, h, time.Second)
+	if err := lc.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	lc.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for h.exits.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := h.exits.Load(); n != 2 {
+		t.Fatalf("%d of 2 consumer loops exited", n)
+	}
+	if n := h.events.Load(); n != 0 {
+		t.Fatalf("consumer loops received %d events after teardown", n)
+	}
+}
+
+// slowStopHandler's Stop blocks until release is closed.
+type slowStopHandler struct {
+	release  chan struct{}
+	stopping atomic.Bool
+	overlaps atomic.Int32
+	inits    atomic.Int32
+}
+
+func (h *slowStopHandler) ValidateConfig(context.Context, map[string]any) error { return nil }
+
+func (h *slowStopHandler) Init(ModuleAPI) error {
+	if h.stopping.Load() {
+		h.overlaps.Add(1)
+	}
+	h.inits.Add(1)
+	return nil
+}
+
+func (h *slowStopHandler) Stop() error {
+	h.stopping.Store(true)
+	<-h.release
+	h.stopping.Store(false)
+	return nil
+}
+
+func TestLifecycleInitWaitsForTimedOutStop(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	h := &slowStopHandler{release: make(chan struct{})}
+	lc := newLifecycle(base, h, 30*time.Millisecond)
+	if err := lc.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop outlives both its timeout and the grace period: Init is refused.
+	if err := lc.Init(nil); !errors.Is(err, errStopRunning) {
+		t.Fatalf("Init with Stop still running: err = %v", err)
+	}
+	if n := h.inits.Load(); n != 1 {
+		t.Fatalf("Init called %d times while Stop was running", n)
+	}
+	if code := errorCode(errStopRunning); code != ErrCodeBusy {
+		t.Fatalf("error code = %q, want %q", code, ErrCodeBusy)
+	}
+
+	// Once the old Stop returns, the next Init goes ahead.
+	close(h.release)
+	if err := lc.Init(nil); err != nil {
+		t.Fatalf("Init after Stop returned: %v", err)
+	}
+	if h.inits.Load() != 2 || h.overlaps.Load() != 0 {
+		t.Fatalf("inits=%d overlaps=%d, want 2/0", h.inits.Load(), h.overlaps.Load())
+	}
+}
@@ -0,0 +1,260 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogBufferSize  = 500
+	defaultLogForwardRate = 20 // records per second
+)
+
+// logEntry is a log record flattened for the ring buffer and the bus.
+type logEntry struct {
+	Time  time.Time
+	Level slog.Level
+	Msg   string
+	Attrs map[string]any
+}
+
+func (e logEntry) line() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", e.Time.UTC().Format(time.RFC3339Nano), e.Level, e.Msg)
+	keys := make([]string, 0, len(e.Attrs))
+	for k := range e.Attrs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, e.Attrs[k])
+	}
+	return b.String()
+}
+
+// logRing keeps the most recent log entries for support diagnostics.
+type logRing struct {
+	mu      sync.Mutex
+	entries []logEntry
+	next    int
+	full    bool
+}
+
+func newLogRing(size int) *logRing {
+	if size <= 0 {
+		size = defaultLogBufferSize
+	}
+	return &logRing{entries: make([]logEntry, size)}
+}
+
+func (r *logRing) add(e logEntry) {
+	r.mu.Lock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// last returns up to n formatted lines, oldest first.
+func (r *logRing) last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	lines := make([]string, 0, n)
+	for i := count - n; i < count; i++ {
+		idx := (r.next - count + i + len(r.entries)) % len(r.entries)
+		lines = append(lines, r.entries[idx].line())
+	}
+	return lines
+}
+
+// busLogKey marks records logged by the bus client itself. They are never
+// forwarded: publishing a report about a failing bus would go through the
+// same bus and could feed back into itself.
+type busLogKey struct{}
+
+var busLogContext = context.WithValue(context.Background(), busLogKey{}, true)
+
+// logForwarder publishes records to logs/<module_id>, rate-limited with a
+// token bucket. It never waits for room in the bus queue. Records dropped by
+// the limiter or a full queue are counted in the next event.
+type logForwarder struct {
+	level slog.Level
+	rate  float64
+
+	mu      sync.Mutex
+	bus     *BusClient
+	topic   string
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+func (f *logForwarder) attach(moduleID string, bus *BusClient) {
+	f.mu.Lock()
+	f.bus = bus
+	f.topic = "logs/" + moduleID
+	f.mu.Unlock()
+}
+
+func (f *logForwarder) forward(e logEntry) {
+	f.mu.Lock()
+	if f.bus == nil {
+		f.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if !f.last.IsZero() {
+		f.tokens += now.Sub(f.last).Seconds() * f.rate
+	} else {
+		f.tokens = f.rate
+	}
+	f.tokens = min(f.tokens, f.rate)
+	f.last = now
+	if f.tokens < 1 {
+		f.dropped++
+		f.mu.Unlock()
+		return
+	}
+	f.tokens--
+	dropped := f.dropped
+	f.dropped = 0
+	bus, topic := f.bus, f.topic
+	f.mu.Unlock()
+
+	data := map[string]any{
+		"time":  e.Time.UTC().Format(time.RFC3339Nano),
+		"level": e.Level.String(),
+		"msg":   e.Msg,
+		"attrs": e.Attrs,
+	}
+	if dropped > 0 {
+		data["dropped"] = dropped
+	}
+	if !bus.tryPublish(topic, "log", data) {
+		f.mu.Lock()
+		f.dropped += dropped + 1
+		f.mu.Unlock()
+	}
+}
+
+// teeHandler passes records to the output handler and also keeps them in the
+// ring buffer and, when forwarding is enabled, publishes them on the bus.
+type teeHandler struct {
+	inner  slog.Handler
+	ring   *logRing
+	fwd    *logForwarder // nil unless forwarding is enabled
+	attrs  []slog.Attr
+	groups string // dotted prefix for attributes added after WithGroup
+}
+
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level) || (h.fwd != nil && level >= h.fwd.level)
+}
+
+func (h *teeHandler) Handle(ctx context.Context, rec slog.Record) error {
+	var err error
+	if h.inner.Enabled(ctx, rec.Level) {
+		err = h.inner.Handle(ctx, rec)
+	}
+	e := logEntry{Time: rec.Time, Level: rec.Level, Msg: rec.Message, Attrs: map[string]any{}}
+	for _, a := range h.attrs {
+		addLogAttr(e.Attrs, "", a)
+	}
+	rec.Attrs(func(a slog.Attr) bool {
+		addLogAttr(e.Attrs, h.groups, a)
+		return true
+	})
+	if h.inner.Enabled(ctx, rec.Level) {
+		h.ring.add(e)
+	}
+	if h.fwd != nil && rec.Level >= h.fwd.level && ctx.Value(busLogKey{}) == nil {
+		h.fwd.forward(e)
+	}
+	return err
+}
+
+// addLogAttr stores a under prefix+key as a value that survives JSON
+// encoding: strings, bools and finite numbers as they are, errors as their
+// text, groups as one key.member entry per member and anything else as its
+// String form.
+func addLogAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	v := a.Value.Resolve()
+	key := prefix + a.Key
+	switch v.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix = key + "."
+		}
+		for _, member := range v.Group() {
+			addLogAttr(attrs, prefix, member)
+		}
+		return
+	case slog.KindString:
+		attrs[key] = v.String()
+	case slog.KindBool:
+		attrs[key] = v.Bool()
+	case slog.KindInt64:
+		attrs[key] = v.Int64()
+	case slog.KindUint64:
+		attrs[key] = v.Uint64()
+	case slog.KindFloat64:
+		if f := v.Float64(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			attrs[key] = f
+		} else {
+			attrs[key] = v.String()
+		}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok && err != nil {
+			attrs[key] = err.Error()
+		} else {
+			attrs[key] = v.String()
+		}
+	default:
+		attrs[key] = v.String()
+	}
+}
+
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.inner = h.inner.WithAttrs(attrs)
+	next.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.groups + a.Key
+		next.attrs = append(next.attrs, a)
+	}
+	return &next
+}
+
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.inner = h.inner.WithGroup(name)
+	next.groups = h.groups + name + "."
+	return &next
+}
+
+// Process-wide log sinks installed by configureLogging.
+var (
+	logBuffer = newLogRing(defaultLogBufferSize)
+	logFwd    *logForwarder
+)
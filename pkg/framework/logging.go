@@ -0,0 +1,90 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// logLevel is the process-wide level of the framework logger. The
+// set_log_level command changes it at runtime.
+var logLevel = new(slog.LevelVar)
+
+// configureLogging installs the framework handler as the slog default, which
+// also routes the standard log package through it. Records are always kept in
+// the ring buffer and, if cfg.LogForward is set, published on the bus once
+// attachLogForwarding is called. An invalid setting is reported in the
+// returned error and replaced by its default; the rest still apply.
+func configureLogging(w io.Writer, cfg RunnerConfig) error {
+	var errs []error
+	if cfg.LogLevel != "" {
+		if lvl, err := parseLogLevel(cfg.LogLevel); err != nil {
+			errs = append(errs, err)
+		} else {
+			logLevel.Set(lvl)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(cfg.LogFormat)) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		errs = append(errs, fmt.Errorf("unsupported log format %q", cfg.LogFormat))
+		h = slog.NewTextHandler(w, opts)
+	}
+
+	logBuffer = newLogRing(cfg.LogBufferSize)
+	logFwd = nil
+	if cfg.LogForward {
+		fwdLevel := slog.LevelInfo
+		if cfg.LogForwardLevel != "" {
+			if lvl, err := parseLogLevel(cfg.LogForwardLevel); err != nil {
+				errs = append(errs, err)
+			} else {
+				fwdLevel = lvl
+			}
+		}
+		rate := cfg.LogForwardRate
+		if rate <= 0 {
+			rate = defaultLogForwardRate
+		}
+		logFwd = &logForwarder{level: fwdLevel, rate: rate}
+	}
+	slog.SetDefault(slog.New(&teeHandler{inner: h, ring: logBuffer, fwd: logFwd}))
+	return errors.Join(errs...)
+}
+
+// attachLogForwarding starts publishing forwarded records on the module's bus.
+func attachLogForwarding(moduleID string, bus *BusClient) {
+	if logFwd != nil {
+		logFwd.attach(moduleID, bus)
+	}
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return lvl, nil
+}
+
+// logf emits a printf-style message; the module ID travels as an attribute so
+// it can never be misread as a format verb.
+func (m *BaseModule) logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !m.log.Enabled(ctx, level) {
+		return
+	}
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+	m.log.Log(ctx, level, msg)
+}
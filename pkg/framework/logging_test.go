@@ -0,0 +1,255 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restoreLogging puts the process-wide logging state back after a test that
+// calls configureLogging.
+func restoreLogging(t *testing.T) {
+	def, lvl, ring, fwd := slog.Default(), logLevel.Level(), logBuffer, logFwd
+	t.Cleanup(func() {
+		slog.SetDefault(def)
+		logLevel.Set(lvl)
+		logBuffer, logFwd = ring, fwd
+	})
+}
+
+func TestModuleLoggingKeepsIDOutOfFormat(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := configureLogging(&buf, RunnerConfig{LogLevel: "info"}); err != nil {
+		t.Fatal(err)
+	}
+	base := NewBaseModule(context.Background(), "mod%d", t.TempDir(), "", nil)
+
+	base.Info("connected to %s", "hub")
+	base.Debug("hidden")
+	out := buf.String()
+	if !strings.Contains(out, `msg="connected to hub"`) || !strings.Contains(out, "module=mod%d") {
+		t.Fatalf("unexpected log output: %s", out)
+	}
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("debug message emitted at info level: %s", out)
+	}
+
+	logLevel.Set(slog.LevelDebug)
+	base.Debug("shown")
+	if !strings.Contains(buf.String(), "shown") {
+		t.Fatalf("debug message not emitted after level change: %s", buf.String())
+	}
+}
+
+func TestConfigureLoggingKeepsValidSettings(t *testing.T) {
+	restoreLogging(t)
+	logLevel.Set(slog.LevelInfo)
+	var buf bytes.Buffer
+	err := configureLogging(&buf, RunnerConfig{LogLevel: "loud", LogFormat: "json"})
+	if err == nil || !strings.Contains(err.Error(), "loud") {
+		t.Fatalf("err = %v, want the invalid level reported", err)
+	}
+	slog.Info("still json")
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil || rec["msg"] != "still json" {
+		t.Fatalf("output %q is not the configured json format: %v", buf.String(), err)
+	}
+	if logLevel.Level() != slog.LevelInfo {
+		t.Fatalf("level = %v, want the previous level kept", logLevel.Level())
+	}
+
+	buf.Reset()
+	err = configureLogging(&buf, RunnerConfig{LogLevel: "warn", LogFormat: "yaml"})
+	if err == nil || !strings.Contains(err.Error(), "yaml") {
+		t.Fatalf("err = %v, want the invalid format reported", err)
+	}
+	slog.Info("hidden")
+	slog.Warn("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown") {
+		t.Fatalf("output %q, want text at warn level", out)
+	}
+}
+
+func TestSetLogLevelCommand(t *testing.T) {
+	restoreLogging(t)
+	logLevel.Set(slog.LevelInfo)
+	_, peer := startTestRunner(t, &scriptedHandler{}, RunnerConfig{})
+
+	sendCommand(peer, "set_log_level", "r1", map[string]any{"level": "debug"})
+	if ack := nextAck(t, peer, "r1"); !ack.OK || ack.Result["level"] != "DEBUG" {
+		t.Fatalf("ack = %+v", ack)
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("level = %v, want debug", logLevel.Level())
+	}
+
+	sendCommand(peer, "set_log_level", "r2", map[string]any{"level": "loud"})
+	if ack := nextAck(t, peer, "r2"); ack.OK || ack.ErrorCode != ErrCodeInvalidRequest {
+		t.Fatalf("ack = %+v, want invalid_request", ack)
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("level = %v after a rejected change", logLevel.Level())
+	}
+}
+
+func TestLogRingKeepsMostRecent(t *testing.T) {
+	ring := newLogRing(3)
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		ring.add(logEntry{Level: slog.LevelInfo, Msg: msg})
+	}
+	lines := ring.last(2)
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "INFO c") || !strings.HasSuffix(lines[1], "INFO d") {
+		t.Fatalf("last(2)=%q", lines)
+	}
+	if got := ring.last(10); len(got) != 3 || !strings.HasSuffix(got[0], "INFO b") {
+		t.Fatalf("last(10)=%q", got)
+	}
+}
+
+// queuedBus returns a client that looks connected but has no writer, so
+// published frames stay in its outbound queue.
+func queuedBus(t *testing.T, queue int) *BusClient {
+	b := NewBusClient("", "mod")
+	client, peer := net.Pipe()
+	t.Cleanup(func() { client.Close(); peer.Close() })
+	b.conn = client
+	b.features = b.legacyFeatures()
+	b.out = make(chan outFrame, queue)
+	return b
+}
+
+func queuedEvents(t *testing.T, b *BusClient) []Event {
+	var events []Event
+	for {
+		select {
+		case f := <-b.out:
+			var ev Event
+			if err := json.Unmarshal(f.data, &ev); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestLogForwardingFiltersLevelAndBusRecords(t *testing.T) {
+	b := queuedBus(t, 10)
+	fwd := &logForwarder{level: slog.LevelWarn, rate: 100}
+	fwd.attach("mod", b)
+	log := slog.New(&teeHandler{inner: slog.NewTextHandler(io.Discard, nil), ring: newLogRing(10), fwd: fwd})
+
+	log.Info("below the forward level")
+	log.Warn("forwarded", "device", "lamp")
+	log.ErrorContext(busLogContext, "bus write failed")
+
+	events := queuedEvents(t, b)
+	if len(events) != 1 {
+		t.Fatalf("forwarded %d events, want 1: %+v", len(events), events)
+	}
+	ev := events[0]
+	if ev.Topic != "logs/mod" || ev.Type != "log" || ev.Data["msg"] != "forwarded" || ev.Data["level"] != "WARN" {
+		t.Fatalf("forwarded %+v", ev)
+	}
+	if attrs, _ := ev.Data["attrs"].(map[string]any); attrs["device"] != "lamp" {
+		t.Fatalf("attrs = %v", ev.Data["attrs"])
+	}
+}
+
+func TestLogForwardingRateLimitCountsDrops(t *testing.T) {
+	b := queuedBus(t, 10)
+	fwd := &logForwarder{level: slog.LevelInfo, rate: 2}
+	fwd.attach("mod", b)
+	for i := range 5 {
+		fwd.forward(logEntry{Time: time.Now(), Level: slog.LevelInfo, Msg: fmt.Sprint(i)})
+	}
+	if events := queuedEvents(t, b); len(events) != 2 {
+		t.Fatalf("forwarded %d events within the burst, want 2", len(events))
+	}
+
+	fwd.mu.Lock()
+	fwd.last = fwd.last.Add(-time.Second) // refill the bucket
+	fwd.mu.Unlock()
+	fwd.forward(logEntry{Time: time.Now(), Level: slog.LevelInfo, Msg: "after"})
+	events := queuedEvents(t, b)
+	if len(events) != 1 || events[0].Data["dropped"] != float64(3) {
+		t.Fatalf("after refill got %+v, want one event reporting 3 dropped", events)
+	}
+}
+
+func TestLogForwardingDoesNotBlockOnFullQueue(t *testing.T) {
+	b := queuedBus(t, 1)
+	fwd := &logForwarder{level: slog.LevelInfo, rate: 100}
+	fwd.attach("mod", b)
+
+	done := make(chan struct{})
+	go func() {
+		for range 3 {
+			fwd.forward(logEntry{Time: time.Now(), Level: slog.LevelInfo, Msg: "x"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("forward blocked on a full bus queue")
+	}
+	queuedEvents(t, b)
+	fwd.forward(logEntry{Time: time.Now(), Level: slog.LevelInfo, Msg: "y"})
+	events := queuedEvents(t, b)
+	if len(events) != 1 || events[0].Data["dropped"] != float64(2) {
+		t.Fatalf("got %+v, want one event reporting 2 dropped", events)
+	}
+}
+
+func TestLogForwardingKeepsAttributeText(t *testing.T) {
+	b := queuedBus(t, 10)
+	fwd := &logForwarder{level: slog.LevelInfo, rate: 100}
+	fwd.attach("mod", b)
+	log := slog.New(&teeHandler{inner: slog.NewTextHandler(io.Discard, nil), ring: newLogRing(10), fwd: fwd})
+
+	log.Error("connect failed",
+		"err", errors.New("connection refused"),
+		slog.Group("req", "id", 7, "host", "hub"),
+		"after", 1500*time.Millisecond,
+		"retry", true,
+	)
+	events := queuedEvents(t, b)
+	if len(events) != 1 {
+		t.Fatalf("forwarded %d events, want 1", len(events))
+	}
+	attrs, _ := events[0].Data["attrs"].(map[string]any)
+	want := map[string]any{"err": "connection refused", "req.id": float64(7), "req.host": "hub", "after": "1.5s", "retry": true}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attrs[%q] = %#v, want %#v", k, attrs[k], v)
+		}
+	}
+}
+
+func TestGetLogsReturnsConfiguredRing(t *testing.T) {
+	restoreLogging(t)
+	size := defaultLogBufferSize + 100
+	logBuffer = newLogRing(size)
+	for i := range size {
+		logBuffer.add(logEntry{Level: slog.LevelInfo, Msg: fmt.Sprint(i)})
+	}
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	result, err := handleBundleAPIRequest(context.Background(), RunnerConfig{}, "", base, &scriptedHandler{}, "get_logs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines, _ := result["lines"].([]string); len(lines) != size {
+		t.Fatalf("got %d lines, want the whole ring of %d", len(lines), size)
+	}
+}
@@ -0,0 +1,161 @@
+package framework
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// RawMapper turns raw entities of one bundle-native kind into abstract ones.
+// Register it with RegisterRawMapper; the framework then derives Entities
+// when an instance is registered and EntityState on UpdateRawState.
+type RawMapper interface {
+	// MapEntity returns the abstract entities backed by raw. The framework
+	// adds raw.ID to their Links.
+	MapEntity(raw RawEntitySpec) []EntitySpec
+	// MapState converts raw state into state of the abstract entities,
+	// keyed by entity ID. It may return nil when nothing maps.
+	MapState(raw RawEntitySpec, state map[string]any) map[string]map[string]any
+}
+
+// FieldMapper is a declarative RawMapper: each raw entity becomes one
+// abstract entity with the same ID, shaped like Spec, whose attributes are
+// renamed raw state fields.
+type FieldMapper struct {
+	// Spec is the template for the abstract entity; ID, Name and Links are
+	// taken from the raw entity.
+	Spec EntitySpec
+	// Fields maps raw state keys to abstract attribute names.
+	Fields map[string]string
+	// Convert optionally transforms a value, keyed by abstract attribute.
+	Convert map[string]func(any) any
+}
+
+func (f FieldMapper) MapEntity(raw RawEntitySpec) []EntitySpec {
+	spec := f.Spec
+	spec.ID, spec.Name, spec.Links = raw.ID, raw.Name, []string{raw.ID}
+	return []EntitySpec{spec}
+}
+
+func (f FieldMapper) MapState(raw RawEntitySpec, state map[string]any) map[string]map[string]any {
+	attrs := map[string]any{}
+	for field, attr := range f.Fields {
+		v, ok := state[field]
+		if !ok {
+			continue
+		}
+		if conv := f.Convert[attr]; conv != nil {
+			v = conv(v)
+		}
+		attrs[attr] = v
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return map[string]map[string]any{raw.ID: attrs}
+}
+
+// RegisterRawMapper sets the mapper for raw entities of rawKind, e.g.
+// "esphome.cover". Registering again replaces it.
+func (m *BaseModule) RegisterRawMapper(rawKind string, mapper RawMapper) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mappers[rawKind] = mapper
+}
+
+func (m *BaseModule) rawMapper(rawKind string) RawMapper {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mappers[rawKind]
+}
+
+// UpdateRawState stores raw state, keyed by raw entity ID, with the instance,
+// merged field by field into what it had, then converts the merged state of
+// the updated raw entities through the registered mappers and publishes the
+// result with UpdateEntityState. Raw entities without a mapper are stored
+// but not mapped. Nothing is stored when the mapped state fails the entity
+// schemas.
+func (m *BaseModule) UpdateRawState(instanceID string, state map[string]map[string]any) error {
+	inst, err := m.im.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("raw state for unknown instance %s: %w", instanceID, err)
+	}
+	// Map and check the merged state before storing any of it.
+	merged := make(map[string]map[string]any, len(state))
+	for id := range state {
+		merged[id] = maps.Clone(inst.RawState[id])
+	}
+	mergeEntityState(merged, state)
+	derived := map[string]map[string]any{}
+	for _, raw := range inst.RawEntities {
+		if _, ok := state[raw.ID]; !ok {
+			continue
+		}
+		if mapper := m.rawMapper(raw.Kind); mapper != nil {
+			mergeEntityState(derived, mapper.MapState(raw, merged[raw.ID]))
+		}
+	}
+	entities, _ := m.im.Entities(instanceID)
+	if err := checkEntityState(entities, derived); err != nil {
+		return fmt.Errorf("raw state for %s rejected: %w", instanceID, err)
+	}
+	if _, err := m.im.UpdateRawState(instanceID, state); err != nil {
+		return fmt.Errorf("raw state for %s: %w", instanceID, err)
+	}
+	if len(derived) == 0 {
+		return nil
+	}
+	return m.UpdateEntityState(instanceID, derived)
+}
+
+// deriveEntities adds the mapped entities of inst's raw entities, and state
+// derived from its raw state. Entities and state the bundle set itself win.
+func (m *BaseModule) deriveEntities(inst *InstanceConfig) {
+	have := map[string]bool{}
+	for _, e := range inst.Entities {
+		have[e.ID] = true
+	}
+	derived := map[string]map[string]any{}
+	for _, raw := range inst.RawEntities {
+		mapper := m.rawMapper(raw.Kind)
+		if mapper == nil {
+			continue
+		}
+		for _, e := range mapper.MapEntity(raw) {
+			if have[e.ID] {
+				continue
+			}
+			if !slices.Contains(e.Links, raw.ID) {
+				e.Links = append(slices.Clone(e.Links), raw.ID)
+			}
+			inst.Entities = append(inst.Entities, e)
+			have[e.ID] = true
+		}
+		if rawState := inst.RawState[raw.ID]; rawState != nil {
+			mergeEntityState(derived, mapper.MapState(raw, rawState))
+		}
+	}
+	if len(derived) == 0 {
+		return
+	}
+	state := maps.Clone(inst.EntityState)
+	if state == nil {
+		state = map[string]map[string]any{}
+	}
+	for id, attrs := range derived {
+		if _, ok := state[id]; !ok {
+			state[id] = attrs
+		}
+	}
+	inst.EntityState = state
+}
+
+// mergeEntityState merges src into dst attribute by attribute.
+func mergeEntityState(dst, src map[string]map[string]any) {
+	for id, attrs := range src {
+		if dst[id] == nil {
+			dst[id] = map[string]any{}
+		}
+		maps.Copy(dst[id], attrs)
+	}
+}
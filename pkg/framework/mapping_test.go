@@ -0,0 +1,103 @@
+package framework
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRawMappersDeriveEntitiesAndState(t *testing.T) {
+	base := NewBaseModule(context.Background(), "esphome", t.TempDir(), "", nil)
+	position := AttributeSpec{Type: AttrInt}.WithRange(0, 100)
+	base.RegisterRawMapper("esphome.cover", FieldMapper{
+		Spec: EntitySpec{Kind: KindActuator, Schema: &EntitySchema{
+			Attributes: map[string]AttributeSpec{"position": position},
+		}},
+		Fields:  map[string]string{"pos": "position"},
+		Convert: map[string]func(any) any{"position": func(v any) any { f, _ := toFloat(v); return int64(f * 100) }},
+	})
+
+	err := base.RegisterInstance(InstanceConfig{
+		ID: "garage",
+		RawEntities: []RawEntitySpec{
+			{ID: "door", Kind: "esphome.cover", Name: "Door"},
+			{ID: "debug", Kind: "esphome.text"},
+		},
+		RawState: map[string]map[string]any{"door": {"pos": 0.5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := base.im.GetInstance("garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inst.Entities) != 1 || inst.Entities[0].ID != "door" || inst.Entities[0].Name != "Door" || inst.Entities[0].Links[0] != "door" {
+		t.Fatalf("entities = %+v", inst.Entities)
+	}
+	if p := inst.EntityState["door"]["position"]; p != float64(50) {
+		t.Fatalf("derived position = %#v", p)
+	}
+
+	if err := base.UpdateRawState("garage", map[string]map[string]any{"door": {"pos": 1.0}, "debug": {"text": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	inst, _ = base.im.GetInstance("garage")
+	if p := inst.EntityState["door"]["position"]; p != float64(100) {
+		t.Fatalf("position after raw update = %#v", p)
+	}
+	if err := base.UpdateRawState("garage", map[string]map[string]any{"door": {"pos": 2.0}}); err == nil {
+		t.Fatalf("mapped state outside the schema accepted")
+	}
+	inst, _ = base.im.GetInstance("garage")
+	if pos := inst.RawState["door"]["pos"]; pos != 1.0 {
+		t.Fatalf("raw pos after rejected update = %#v, want 1 kept", pos)
+	}
+	if p := inst.EntityState["door"]["position"]; p != float64(100) {
+		t.Fatalf("position after rejected update = %#v", p)
+	}
+}
+
+func TestUpdateRawStateMergesAndPersists(t *testing.T) {
+	base := NewBaseModule(context.Background(), "esphome", t.TempDir(), "", nil)
+	var seen map[string]any
+	base.RegisterRawMapper("esphome.light", rawMapperFunc(func(raw RawEntitySpec, state map[string]any) map[string]map[string]any {
+		seen = state
+		return nil
+	}))
+	err := base.RegisterInstance(InstanceConfig{
+		ID:          "hall",
+		RawEntities: []RawEntitySpec{{ID: "bulb", Kind: "esphome.light"}, {ID: "debug", Kind: "esphome.text"}},
+		RawState:    map[string]map[string]any{"bulb": {"on": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := base.UpdateRawState("hall", map[string]map[string]any{"bulb": {"level": 0.4}, "debug": {"text": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if seen["on"] != true || seen["level"] != 0.4 {
+		t.Fatalf("mapper saw %v, want the merged raw state", seen)
+	}
+	inst, err := base.im.GetInstance("hall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bulb := inst.RawState["bulb"]; bulb["on"] != true || bulb["level"] != 0.4 {
+		t.Fatalf("stored bulb = %v", bulb)
+	}
+	if inst.RawState["debug"]["text"] != "x" {
+		t.Fatalf("unmapped raw state not stored: %v", inst.RawState)
+	}
+	if err := base.UpdateRawState("gone", map[string]map[string]any{"bulb": {"on": false}}); err == nil {
+		t.Fatal("raw state for an unknown instance accepted")
+	}
+}
+
+type rawMapperFunc func(RawEntitySpec, map[string]any) map[string]map[string]any
+
+func (f rawMapperFunc) MapEntity(RawEntitySpec) []EntitySpec { return nil }
+
+func (f rawMapperFunc) MapState(raw RawEntitySpec, state map[string]any) map[string]map[string]any {
+	return f(raw, state)
+}
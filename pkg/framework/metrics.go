@@ -0,0 +1,165 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/metrics"
+)
+
+// topicPrefix is the first topic segment, used as a low-cardinality label.
+func topicPrefix(topic string) string {
+	if i := strings.IndexByte(topic, '/'); i >= 0 {
+		return topic[:i]
+	}
+	return topic
+}
+
+type busMetrics struct {
+	published *metrics.Counter
+	received  *metrics.Counter
+	dropped   *metrics.Counter
+}
+
+func newBusMetrics(reg *metrics.Registry) *busMetrics {
+	return &busMetrics{
+		published: reg.Counter("bus_events_published_total", "Events published by this module.", "prefix"),
+		received:  reg.Counter("bus_events_received_total", "Events received from the bus.", "prefix"),
+		dropped:   reg.Counter("bus_events_dropped_total", "Events dropped before delivery.", "prefix", "reason"),
+	}
+}
+
+// useMetrics moves the client's instruments to reg. Call it before Start.
+func (b *BusClient) useMetrics(reg *metrics.Registry) {
+	b.metrics = newBusMetrics(reg)
+}
+
+type instanceMetrics struct {
+	writeSeconds *metrics.Histogram
+	files        *metrics.Gauge
+}
+
+func newInstanceMetrics(reg *metrics.Registry) *instanceMetrics {
+	return &instanceMetrics{
+		writeSeconds: reg.Histogram("instance_write_seconds", "Latency of instance persistence operations.", nil, "op"),
+		files:        reg.Gauge("instance_files", "Instance files on disk by kind.", "kind"),
+	}
+}
+
+// useMetrics moves the manager's instruments to reg and records current file counts.
+func (im *InstanceManager) useMetrics(reg *metrics.Registry) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.metrics = newInstanceMetrics(reg)
+	if im.files != nil {
+		im.setFileCounts()
+	} else {
+		im.loadIndex()
+	}
+}
+
+func (im *InstanceManager) observeWrite(op string, start time.Time) {
+	im.metrics.writeSeconds.Observe(time.Since(start).Seconds(), op)
+}
+
+// fileKind is the instance_files label of a file in the instances
+// directory, or "" for files that are not counted.
+func fileKind(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".instance.json"):
+		return "instance"
+	case strings.HasSuffix(name, ".script.state.json"), strings.HasSuffix(name, ".script"):
+		return "script"
+	case strings.HasSuffix(name, ".state.json"):
+		return "state"
+	}
+	return ""
+}
+
+// setFileCounts sets the file gauges from the in-memory file index. im.mu
+// must be held.
+func (im *InstanceManager) setFileCounts() {
+	counts := map[string]int{"instance": 0, "state": 0, "script": 0}
+	for name := range im.files {
+		if kind := fileKind(name); kind != "" {
+			counts[kind]++
+		}
+	}
+	for kind, n := range counts {
+		im.metrics.files.Set(float64(n), kind)
+	}
+}
+
+// trackFile records that the named file was written or removed and adjusts
+// its gauge. im.mu must be held.
+func (im *InstanceManager) trackFile(name string, exists bool) {
+	im.loadIndex()
+	kind := fileKind(name)
+	if kind == "" || im.files[name] == exists {
+		return
+	}
+	if exists {
+		im.files[name] = true
+		im.metrics.files.Add(1, kind)
+	} else {
+		delete(im.files, name)
+		im.metrics.files.Add(-1, kind)
+	}
+}
+
+type commandMetrics struct {
+	total    *metrics.Counter
+	duration *metrics.Histogram
+}
+
+func newCommandMetrics(reg *metrics.Registry) *commandMetrics {
+	return &commandMetrics{
+		total:    reg.Counter("commands_total", "Runner commands by outcome (ok or error code).", "command", "outcome"),
+		duration: reg.Histogram("command_duration_seconds", "Runner command handling time.", nil, "command"),
+	}
+}
+
+func newDeviceCommandMetrics(reg *metrics.Registry) *commandMetrics {
+	return &commandMetrics{
+		total:    reg.Counter("device_commands_total", "Device commands by outcome (ok or error code).", "command", "outcome"),
+		duration: reg.Histogram("device_command_duration_seconds", "Device command handling time.", nil, "command"),
+	}
+}
+
+func (c *commandMetrics) observe(command string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = errorCode(err)
+	}
+	c.total.Inc(command, outcome)
+	c.duration.Observe(time.Since(start).Seconds(), command)
+}
+
+// serveMetrics exposes reg on addr at /metrics until the returned server is shut down.
+func serveMetrics(addr string, reg *metrics.Registry) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+	return srv, nil
+}
+
+func shutdownMetrics(ctx context.Context, srv *http.Server) error {
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
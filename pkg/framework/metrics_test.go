@@ -0,0 +1,100 @@
+package framework
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lms-io/module-framework/pkg/metrics"
+)
+
+// checkMetrics fails unless the exposition text contains every line.
+func checkMetrics(t *testing.T, text string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, text)
+		}
+	}
+}
+
+func exposition(t *testing.T, reg *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestBusMetricsCountTraffic(t *testing.T) {
+	reg := metrics.NewRegistry()
+	b := queuedBus(t, 1)
+	b.useMetrics(reg)
+
+	b.Publish("state/lamp", "update", map[string]any{"on": true})
+	b.tryPublish("logs/mod", "log", map[string]any{"msg": "queue is full"})
+	b.dispatch(Event{Topic: "commands/mod", Type: "get_config"})
+
+	checkMetrics(t, exposition(t, reg),
+		`bus_events_published_total{prefix="state"} 1`,
+		`bus_events_dropped_total{prefix="logs",reason="queue_full"} 1`,
+		`bus_events_received_total{prefix="commands"} 1`,
+	)
+}
+
+func TestGetMetricsReportsCommands(t *testing.T) {
+	_, peer := startTestRunner(t, &scriptedHandler{}, RunnerConfig{})
+
+	sendCommand(peer, "delete_instance", "r1", nil)
+	nextAck(t, peer, "r1")
+	sendCommand(peer, "no_such_command", "r2", nil)
+	nextAck(t, peer, "r2")
+	sendCommand(peer, "bundle_api", "r3", map[string]any{"action": "get_metrics"})
+	ack := nextAck(t, peer, "r3")
+	text, _ := ack.Result["text"].(string)
+	if !ack.OK || ack.Result["format"] != "prometheus" {
+		t.Fatalf("ack = %+v", ack)
+	}
+	checkMetrics(t, text,
+		`commands_total{command="delete_instance",outcome="invalid_request"} 1`,
+		`commands_total{command="no_such_command",outcome="unsupported"} 1`,
+		`command_duration_seconds_count{command="delete_instance"} 1`,
+		`bus_events_received_total{prefix="commands"} 3`,
+	)
+}
+
+func TestInstanceFileMetricsTrackWrites(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "instances"), 0755)
+	os.WriteFile(filepath.Join(dir, "instances", "old.instance.json"), []byte(`{"id":"old"}`), 0644)
+	base := NewBaseModule(context.Background(), "mod", dir, "", nil)
+	checkMetrics(t, exposition(t, base.metrics),
+		`instance_files{kind="instance"} 1`,
+		`instance_files{kind="state"} 0`,
+	)
+
+	err := base.RegisterInstance(InstanceConfig{ID: "lamp", EntityState: map[string]map[string]any{"light": {"on": true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.im.SaveScript("lamp", "blink()"); err != nil {
+		t.Fatal(err)
+	}
+	checkMetrics(t, exposition(t, base.metrics),
+		`instance_files{kind="instance"} 2`,
+		`instance_files{kind="state"} 1`,
+		`instance_files{kind="script"} 1`,
+	)
+
+	if err := base.im.DeleteInstance("lamp"); err != nil {
+		t.Fatal(err)
+	}
+	checkMetrics(t, exposition(t, base.metrics),
+		`instance_files{kind="instance"} 1`,
+		`instance_files{kind="state"} 0`,
+		`instance_files{kind="script"} 0`,
+	)
+}
@@ -0,0 +1,102 @@
+package framework
+
+import (
+	"maps"
+	"math"
+
+	"github.com/lms-io/module-framework/pkg/units"
+)
+
+// SetUnitSystem makes UpdateEntityState publish numeric attributes that
+// declare a Unit in the units sys prefers. Persisted state stays in the
+// module's native units. Numeric device command args are read in the same
+// units and converted back to native ones before the schema check and the
+// handler, so a handler always sees native values. units.Native turns
+// conversion off.
+func (m *BaseModule) SetUnitSystem(sys units.System) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.units = sys
+}
+
+// normalizeUnits fills in the units of update and converts its values to the
+// configured unit system, recording the originals in Native. The caller's
+// state maps are not modified.
+func (m *BaseModule) normalizeUnits(entities []EntitySpec, update StateUpdate) StateUpdate {
+	m.mu.Lock()
+	sys := m.units
+	m.mu.Unlock()
+	state := maps.Clone(update.EntityState)
+	for _, e := range entities {
+		attrs := state[e.ID]
+		if attrs == nil || e.Schema == nil {
+			continue
+		}
+		converted := false
+		for name, v := range attrs {
+			spec, ok := e.Schema.Attributes[name]
+			if !ok || spec.Unit == "" {
+				continue
+			}
+			unit := spec.Unit
+			if f, ok := toFloat(v); ok {
+				if to := sys.Preferred(unit); to != unit {
+					if c, err := units.Convert(f, unit, to); err == nil {
+						if !converted {
+							attrs = maps.Clone(attrs)
+							state[e.ID] = attrs
+							converted = true
+						}
+						attrs[name] = roundConverted(c)
+						setNested(&update.Native, e.ID, name, NativeValue{Value: v, Unit: unit})
+						unit = to
+					}
+				}
+			}
+			setNested(&update.Units, e.ID, name, unit)
+		}
+	}
+	update.EntityState = state
+	return update
+}
+
+// nativeArgs converts the numeric args of a device command from the
+// configured unit system to the native units of the schema. An arg's unit is
+// its own Unit, or else that of the attribute with the same name. args is
+// modified in place.
+func (m *BaseModule) nativeArgs(schema *EntitySchema, command string, args map[string]any) {
+	m.mu.Lock()
+	sys := m.units
+	m.mu.Unlock()
+	specs := schema.Commands[command].Args
+	for name, v := range args {
+		unit := specs[name].Unit
+		if unit == "" {
+			unit = schema.Attributes[name].Unit
+		}
+		f, ok := toFloat(v)
+		if unit == "" || !ok {
+			continue
+		}
+		if from := sys.Preferred(unit); from != unit {
+			if c, err := units.Convert(f, from, unit); err == nil {
+				args[name] = roundConverted(c)
+			}
+		}
+	}
+}
+
+// roundConverted hides floating point noise such as 71.60000000000001.
+func roundConverted(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+func setNested[V any](m *map[string]map[string]V, outer, inner string, v V) {
+	if *m == nil {
+		*m = map[string]map[string]V{}
+	}
+	if (*m)[outer] == nil {
+		(*m)[outer] = map[string]V{}
+	}
+	(*m)[outer][inner] = v
+}
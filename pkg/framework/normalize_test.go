@@ -0,0 +1,75 @@
+package framework
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lms-io/module-framework/pkg/units"
+)
+
+func TestNormalizeUnitsKeepsNativeValues(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	base.SetUnitSystem(units.Metric)
+	entities := []EntitySpec{{ID: "temp", Kind: KindSensor, Schema: &EntitySchema{Attributes: map[string]AttributeSpec{
+		"value":   {Type: AttrFloat, Unit: units.Fahrenheit},
+		"battery": {Type: AttrInt, Unit: "%"},
+		"label":   {Type: AttrString},
+	}}}}
+	state := map[string]map[string]any{"temp": {"value": 71.6, "battery": 90, "label": "hall"}}
+
+	got := base.normalizeUnits(entities, StateUpdate{ID: "thermo", EntityState: state})
+	if v := got.EntityState["temp"]["value"]; v != 22.0 {
+		t.Fatalf("value = %#v, want 22.0", v)
+	}
+	if got.Units["temp"]["value"] != units.Celsius || got.Units["temp"]["battery"] != "%" {
+		t.Fatalf("units = %v", got.Units)
+	}
+	if n := got.Native["temp"]["value"]; n.Value != 71.6 || n.Unit != units.Fahrenheit {
+		t.Fatalf("native = %+v", got.Native)
+	}
+	if _, ok := got.Native["temp"]["battery"]; ok {
+		t.Fatalf("unconverted attribute recorded as native: %+v", got.Native)
+	}
+	if state["temp"]["value"] != 71.6 {
+		t.Fatalf("caller's state modified")
+	}
+}
+
+func TestDeviceCommandArgsConvertedToNativeUnits(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	base.SetUnitSystem(units.Imperial)
+	peer := connectTestBus(t, base.bus)
+	setpoint := AttributeSpec{Type: AttrFloat}.WithRange(5, 30)
+	err := base.RegisterInstance(InstanceConfig{ID: "thermo", Entities: []EntitySpec{{
+		ID: "heat", Kind: KindActuator, Schema: &EntitySchema{
+			Attributes: map[string]AttributeSpec{"target": {Type: AttrFloat, Unit: units.Celsius}},
+			Commands: map[string]CommandSpec{
+				// target takes its unit from the attribute, limit declares its own.
+				"set":   {Args: map[string]AttributeSpec{"target": setpoint}},
+				"limit": {Args: map[string]AttributeSpec{"max": {Type: AttrFloat, Unit: units.Celsius}}},
+			},
+		},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan map[string]any, 2)
+	handler := func(_ context.Context, args map[string]any) error { got <- args; return nil }
+	base.HandleDeviceCommand("thermo", "heat", "set", handler)
+	base.HandleDeviceCommand("thermo", "heat", "limit", handler)
+
+	// 71.6 °F is outside the native 5-30 °C range until converted.
+	peer.send(NewDeviceCommand("thermo", "heat", "set", "r1", map[string]any{"target": 71.6}))
+	if result, _ := DecodePayload[CommandResult](peer.next(t, DeviceResultTopic("thermo"))); !result.OK {
+		t.Fatalf("result = %+v", result)
+	}
+	if args := <-got; args["target"] != 22.0 {
+		t.Fatalf("handler got target %#v, want 22.0 °C", args["target"])
+	}
+
+	peer.send(NewDeviceCommand("thermo", "heat", "limit", "r2", map[string]any{"max": 50.0}))
+	peer.next(t, DeviceResultTopic("thermo"))
+	if args := <-got; args["max"] != 10.0 {
+		t.Fatalf("handler got max %#v, want 10.0 °C", args["max"])
+	}
+}
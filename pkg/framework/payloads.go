@@ -0,0 +1,151 @@
+package framework
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+// Payloads of the events the framework publishes. Consumers decode them with
+// DecodePayload or Typed instead of picking through Event.Data.
+
+// RegisterPayload is published on sys/register when an instance is added or
+// updated.
+type RegisterPayload struct {
+	Bundle string `json:"bundle"`
+	InstanceConfig
+}
+
+// UnregisterPayload is published on sys/unregister when an instance is deleted.
+type UnregisterPayload struct {
+	ID     string `json:"id"`
+	Bundle string `json:"bundle"`
+}
+
+// BundleStatusPayload is the retained sys/bundle_status event.
+type BundleStatusPayload struct {
+	Bundle  string         `json:"bundle"`
+	State   BundleState    `json:"state"`
+	Message string         `json:"message"`
+	Config  map[string]any `json:"config"`
+	// Unavailable lists instances that went away with the bundle; it is only
+	// set in the bundle's last-will.
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// StateUpdate is the retained state/<id> event, and state/<id>/<entity> with
+// EntityID set and a single entry in EntityState.
+type StateUpdate struct {
+	ID          string                    `json:"id"`
+	EntityID    string                    `json:"entity_id,omitempty"`
+	EntityState map[string]map[string]any `json:"entity_state"`
+	// Units is the unit of each attribute that declares one, keyed by entity
+	// and attribute.
+	Units map[string]map[string]string `json:"units,omitempty"`
+	// Native holds the values as the module reported them, for attributes
+	// converted to the configured unit system.
+	Native map[string]map[string]NativeValue `json:"native,omitempty"`
+}
+
+// NativeValue is an attribute value in the unit the module reported it in.
+type NativeValue struct {
+	Value any    `json:"value"`
+	Unit  string `json:"unit"`
+}
+
+// forEntity narrows the update to one entity for state/<id>/<entity>.
+func (u StateUpdate) forEntity(entityID string) StateUpdate {
+	out := StateUpdate{
+		ID:          u.ID,
+		EntityID:    entityID,
+		EntityState: map[string]map[string]any{entityID: u.EntityState[entityID]},
+	}
+	if units, ok := u.Units[entityID]; ok {
+		out.Units = map[string]map[string]string{entityID: units}
+	}
+	if native, ok := u.Native[entityID]; ok {
+		out.Native = map[string]map[string]NativeValue{entityID: native}
+	}
+	return out
+}
+
+// InstancesResponse is published on sys/instances_response for get_instances.
+type InstancesResponse struct {
+	Bundle    string           `json:"bundle"`
+	RequestID string           `json:"request_id,omitempty"` // of the get_instances command
+	Instances []InstanceConfig `json:"instances"`
+}
+
+// BundleAPIRequest is the data of a bundle_api command.
+type BundleAPIRequest struct {
+	RequestID string         `json:"request_id"`
+	Action    string         `json:"action"`
+	Params    map[string]any `json:"params,omitempty"`
+}
+
+// BundleAPIResponse is published on sys/bundle_api_response. The keys of
+// Result appear at the top level of the event data next to the fixed fields.
+type BundleAPIResponse struct {
+	Bundle    string         `json:"bundle"`
+	RequestID string         `json:"request_id"`
+	Action    string         `json:"action"`
+	OK        bool           `json:"ok"`
+	Error     string         `json:"error,omitempty"`
+	Result    map[string]any `json:"-"`
+}
+
+type bundleAPIResponseFields BundleAPIResponse
+
+func (r BundleAPIResponse) MarshalJSON() ([]byte, error) {
+	fixed, err := EncodePayload(bundleAPIResponseFields(r))
+	if err != nil {
+		return nil, err
+	}
+	out := maps.Clone(r.Result)
+	if out == nil {
+		out = map[string]any{}
+	}
+	maps.Copy(out, fixed)
+	return json.Marshal(out)
+}
+
+func (r *BundleAPIResponse) UnmarshalJSON(data []byte) error {
+	var fixed bundleAPIResponseFields
+	if err := json.Unmarshal(data, &fixed); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, k := range []string{"bundle", "request_id", "action", "ok", "error"} {
+		delete(all, k)
+	}
+	*r = BundleAPIResponse(fixed)
+	if len(all) > 0 {
+		r.Result = all
+	}
+	return nil
+}
+
+// CommandAck is published on ResponseTopic for every command a module handles.
+type CommandAck struct {
+	Bundle    string         `json:"bundle"`
+	Command   string         `json:"command"`
+	RequestID string         `json:"request_id"`
+	OK        bool           `json:"ok"`
+	ErrorCode string         `json:"error_code,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Result    map[string]any `json:"result,omitempty"`
+}
+
+// CommandResult is published on DeviceResultTopic(instanceID) for every device
+// command routed through HandleDeviceCommand.
+type CommandResult struct {
+	Instance  string `json:"instance"`
+	EntityID  string `json:"entity_id"`
+	Command   string `json:"command"`
+	RequestID string `json:"request_id"`
+	OK        bool   `json:"ok"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
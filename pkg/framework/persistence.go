@@ -3,30 +3,45 @@ package framework
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/metrics"
 )
 
 // InstanceManager handles saving/loading device configurations and states to disk.
 type InstanceManager struct {
 	stateDir string
 	moduleID string
+	log      *slog.Logger
+	metrics  *instanceMetrics
 	mu       sync.RWMutex
+	// entities holds the entity specs of every instance on disk, keyed by
+	// instance ID, and files the names of the files in the instances
+	// directory. Both are loaded on first use and kept up to date by the
+	// manager's writes, so counting instances and files and checking state
+	// against schemas need no disk reads.
+	entities map[string][]EntitySpec
+	files    map[string]bool
 }
 
 func NewInstanceManager(stateDir, moduleID string) *InstanceManager {
 	return &InstanceManager{
 		stateDir: stateDir,
 		moduleID: moduleID,
+		log:      slog.Default().With("module", moduleID),
+		metrics:  newInstanceMetrics(metrics.NewRegistry()),
 	}
 }
 
 func (im *InstanceManager) RegisterInstance(payload InstanceConfig) error {
 	im.mu.Lock()
 	defer im.mu.Unlock()
+	defer im.observeWrite("register", time.Now())
 
 	dir := filepath.Join(im.stateDir, "instances")
 	os.MkdirAll(dir, 0755)
@@ -50,6 +65,9 @@ func (im *InstanceManager) RegisterInstance(payload InstanceConfig) error {
 	if err := os.WriteFile(instancePath, data, 0644); err != nil {
 		return err
 	}
+	im.loadIndex()
+	im.entities[payload.ID] = payload.Entities
+	im.trackFile(filepath.Base(instancePath), true)
 
 	// 2. Save live entity state separately if provided
 	if len(payload.EntityState) > 0 {
@@ -62,6 +80,7 @@ func (im *InstanceManager) RegisterInstance(payload InstanceConfig) error {
 func (im *InstanceManager) DeleteInstance(id string) error {
 	im.mu.Lock()
 	defer im.mu.Unlock()
+	defer im.observeWrite("delete", time.Now())
 
 	dir := filepath.Join(im.stateDir, "instances")
 	paths := []string{
@@ -70,15 +89,17 @@ func (im *InstanceManager) DeleteInstance(id string) error {
 		filepath.Join(dir, id+".script"),
 		filepath.Join(dir, id+".script.state.json"),
 	}
-	log.Printf("[%s] DeleteInstance start id=%s dir=%s", im.moduleID, id, dir)
+	log := im.log.With("instance", id)
+	log.Debug("DeleteInstance start", "dir", dir)
 	var firstErr error
 	for _, p := range paths {
 		if err := os.Remove(p); err != nil {
 			if os.IsNotExist(err) {
-				log.Printf("[%s] DeleteInstance id=%s file missing (already absent): %s", im.moduleID, id, p)
+				log.Debug("DeleteInstance file missing (already absent)", "path", p)
+				im.trackFile(filepath.Base(p), false)
 				continue
 			}
-			log.Printf("[%s] DeleteInstance id=%s remove failed path=%s err=%v", im.moduleID, id, p, err)
+			log.Error("DeleteInstance remove failed", "path", p, "error", err)
 			if firstErr == nil {
 				firstErr = err
 			}
@@ -88,32 +109,111 @@ func (im *InstanceManager) DeleteInstance(id string) error {
 		// Verify the file is actually gone immediately after remove.
 		if _, err := os.Stat(p); err == nil {
 			verifyErr := fmt.Errorf("delete verification failed, file still exists: %s", p)
-			log.Printf("[%s] DeleteInstance id=%s %v", im.moduleID, id, verifyErr)
+			log.Error("DeleteInstance verification failed", "error", verifyErr)
 			if firstErr == nil {
 				firstErr = verifyErr
 			}
 			continue
 		} else if !os.IsNotExist(err) {
-			log.Printf("[%s] DeleteInstance id=%s stat-after-delete failed path=%s err=%v", im.moduleID, id, p, err)
+			log.Error("DeleteInstance stat-after-delete failed", "path", p, "error", err)
 			if firstErr == nil {
 				firstErr = err
 			}
 			continue
 		}
-		log.Printf("[%s] DeleteInstance id=%s deleted path=%s", im.moduleID, id, p)
+		im.trackFile(filepath.Base(p), false)
+		log.Debug("DeleteInstance deleted", "path", p)
 	}
 	if firstErr != nil {
-		log.Printf("[%s] DeleteInstance done id=%s with error=%v", im.moduleID, id, firstErr)
+		log.Error("DeleteInstance done with error", "error", firstErr)
 	} else {
-		log.Printf("[%s] DeleteInstance done id=%s success", im.moduleID, id)
+		im.loadIndex()
+		delete(im.entities, id)
+		log.Info("DeleteInstance done")
 	}
 	return firstErr
 }
 
+// Count returns the number of instances on disk.
+func (im *InstanceManager) Count() int {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.loadIndex()
+	return len(im.entities)
+}
+
+// Entities returns the entity specs of an instance and whether it exists.
+func (im *InstanceManager) Entities(id string) ([]EntitySpec, bool) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.loadIndex()
+	entities, ok := im.entities[id]
+	return entities, ok
+}
+
+// loadIndex reads the entity specs of every instance and the names of the
+// instance files from disk the first time it is called. im.mu must be held.
+func (im *InstanceManager) loadIndex() {
+	if im.entities != nil {
+		return
+	}
+	im.entities = make(map[string][]EntitySpec)
+	im.files = make(map[string]bool)
+	dir := filepath.Join(im.stateDir, "instances")
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		im.files[f.Name()] = true
+		id, ok := strings.CutSuffix(f.Name(), ".instance.json")
+		if !ok {
+			continue
+		}
+		var inst struct {
+			Entities []EntitySpec `json:"entities"`
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, f.Name())); err == nil && json.Unmarshal(data, &inst) == nil {
+			im.entities[id] = inst.Entities
+		}
+	}
+	im.setFileCounts()
+}
+
 func (im *InstanceManager) UpdateEntityState(id string, state map[string]map[string]any) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	defer im.observeWrite("entity_state", time.Now())
 	return im.saveEntityState(id, state)
 }
 
+// UpdateRawState merges state, keyed by raw entity ID, into the raw state
+// stored with the instance, field by field, and returns the merged result.
+func (im *InstanceManager) UpdateRawState(id string, state map[string]map[string]any) (map[string]map[string]any, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	defer im.observeWrite("raw_state", time.Now())
+
+	path := filepath.Join(im.stateDir, "instances", id+".instance.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inst InstanceConfig
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return nil, err
+	}
+	if inst.RawState == nil {
+		inst.RawState = map[string]map[string]any{}
+	}
+	mergeEntityState(inst.RawState, state)
+	if data, err = json.MarshalIndent(inst, "", "  "); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return inst.RawState, nil
+}
+
+// saveEntityState writes the state file of an instance. im.mu must be held.
 func (im *InstanceManager) saveEntityState(id string, state map[string]map[string]any) error {
 	dir := filepath.Join(im.stateDir, "instances")
 	path := filepath.Join(dir, id+".state.json")
@@ -121,7 +221,29 @@ func (im *InstanceManager) saveEntityState(id string, state map[string]map[strin
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	im.trackFile(filepath.Base(path), true)
+	return nil
+}
+
+// SaveScript writes the script of an instance.
+func (im *InstanceManager) SaveScript(id, content string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	defer im.observeWrite("script", time.Now())
+
+	dir := filepath.Join(im.stateDir, "instances")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, id+".script")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	im.trackFile(filepath.Base(path), true)
+	return nil
 }
 
 func (im *InstanceManager) GetInstances() ([]InstanceConfig, error) {
@@ -146,6 +268,11 @@ func (im *InstanceManager) GetInstances() ([]InstanceConfig, error) {
 	return instances, nil
 }
 
+// GetInstance loads a single instance with its entity state.
+func (im *InstanceManager) GetInstance(id string) (InstanceConfig, error) {
+	return im.loadJSONInstance(filepath.Join(im.stateDir, "instances", id+".instance.json"))
+}
+
 func (im *InstanceManager) loadJSONInstance(path string) (InstanceConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,90 @@
+package framework
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Directions of recorded events relative to the module.
+const (
+	DirIn  = "in"  // received from the bus
+	DirOut = "out" // published by the module
+)
+
+// Record is one line of a bus recording.
+type Record struct {
+	Time      time.Time `json:"ts"`
+	Direction string    `json:"dir"`
+	Event     Event     `json:"event"`
+}
+
+// Recorder writes every event seen by a BusClient as timestamped JSON lines.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// CreateRecording opens a recording file. If path is an existing directory, a
+// file named <moduleID>-<timestamp>.jsonl is created inside it.
+func CreateRecording(path, moduleID string) (*Recorder, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		name := fmt.Sprintf("%s-%s.jsonl", moduleID, time.Now().UTC().Format("20060102T150405Z"))
+		path = filepath.Join(path, name)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{w: f, closer: f}, nil
+}
+
+func (r *Recorder) Record(dir string, ev Event) {
+	data, err := json.Marshal(Record{Time: time.Now().UTC(), Direction: dir, Event: ev})
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.w.Write(append(data, '\n'))
+	r.mu.Unlock()
+}
+
+// Close closes the underlying file, if the recorder opened one.
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// ReadRecording parses a recording written by a Recorder.
+func ReadRecording(rd io.Reader) ([]Record, error) {
+	var out []Record
+	br := bufio.NewReader(rd)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if len(data) > 0 && !(len(data) == 1 && data[0] == '\n') {
+			var rec Record
+			if jerr := json.Unmarshal(data, &rec); jerr != nil {
+				return out, fmt.Errorf("line %d: %w", line, jerr)
+			}
+			out = append(out, rec)
+		}
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
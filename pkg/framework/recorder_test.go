@@ -0,0 +1,41 @@
+package framework
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	in := Event{Topic: "commands/mod", Type: "refresh", Source: "ui", Data: map[string]any{"request_id": "r1"}}
+	out := Event{Topic: "state/lamp", Type: "update", Source: "mod", Retain: true, TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		Data: map[string]any{"on": true, "brightness": 40.0, "tags": []any{"a"}}}
+	rec.Record(DirIn, in)
+	rec.Record(DirOut, out)
+
+	records, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+	for i, want := range []Record{{Direction: DirIn, Event: in}, {Direction: DirOut, Event: out}} {
+		got := records[i]
+		if got.Direction != want.Direction || !reflect.DeepEqual(got.Event, want.Event) {
+			t.Errorf("record %d = %+v, want %+v", i, got, want)
+		}
+		if got.Time.IsZero() {
+			t.Errorf("record %d has no timestamp", i)
+		}
+	}
+}
+
+func TestReadRecordingReportsBadLine(t *testing.T) {
+	_, err := ReadRecording(bytes.NewBufferString("{\"dir\":\"in\"}\nnot json\n"))
+	if err == nil {
+		t.Fatal("ReadRecording accepted a malformed line")
+	}
+}
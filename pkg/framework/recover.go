@@ -0,0 +1,55 @@
+package framework
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned in place of a panic recovered from a handler callback.
+type PanicError struct {
+	Callback string
+	Value    any
+	Stack    []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Callback, e.Value)
+}
+
+// safeCall runs fn and converts a panic into a *PanicError, reporting it as a
+// crash. cmd, when non-nil, is the command that led to the callback.
+func (m *BaseModule) safeCall(callback string, cmd *Event, fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			perr := &PanicError{Callback: callback, Value: v, Stack: debug.Stack()}
+			m.reportCrash(perr, cmd)
+			err = perr
+		}
+	}()
+	return fn()
+}
+
+// reportCrash logs the panic, publishes it on sys/crash and flips the bundle
+// status to error.
+func (m *BaseModule) reportCrash(perr *PanicError, cmd *Event) {
+	attrs := []any{"callback", perr.Callback, "panic", fmt.Sprint(perr.Value), "stack", string(perr.Stack)}
+	if cmd != nil {
+		attrs = append(attrs, "command", cmd.Type, "request_id", asString(cmd.Data["request_id"]))
+	}
+	m.log.Error("recovered panic", attrs...)
+	data := map[string]any{
+		"bundle":   m.id,
+		"callback": perr.Callback,
+		"panic":    fmt.Sprint(perr.Value),
+		"stack":    string(perr.Stack),
+	}
+	if cmd != nil {
+		data["command"] = map[string]any{
+			"topic":      cmd.Topic,
+			"type":       cmd.Type,
+			"request_id": asString(cmd.Data["request_id"]),
+		}
+	}
+	m.bus.Publish("sys/crash", "crash", data)
+	m.SetBundleStatus(BundleStatus{State: StateError, Message: perr.Error()})
+}
@@ -0,0 +1,99 @@
+package framework
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RetainedTopic carries requests for, and snapshots of, retained events
+// between a client and the broker. It is never fanned out.
+const RetainedTopic = "sys/retained"
+
+// CapRetain means the broker keeps the latest retained event per topic and
+// publisher and answers snapshot requests.
+const CapRetain = "retain"
+
+// ClearType is the type of the event that clears a retained event. Live
+// subscribers receive it like any other event, so they can tell a removed
+// device from an update.
+const ClearType = "clear"
+
+// retainedTimeout bounds how long a new subscription holds back live events
+// while waiting for its snapshot. It is a variable for tests.
+var retainedTimeout = 2 * time.Second
+
+// maxBacklog bounds the live events held back while a snapshot is pending.
+const maxBacklog = 1000
+
+// PublishRetained publishes an event the broker keeps as the latest for its
+// topic and publisher. Publishing nil data clears it; the event is then sent
+// with type ClearType whatever eventType says.
+func (b *BusClient) PublishRetained(topic, eventType string, data map[string]any) {
+	b.PublishRetainedContext(context.Background(), topic, eventType, data)
+}
+
+// PublishRetainedContext is PublishRetained within the trace carried by ctx.
+func (b *BusClient) PublishRetainedContext(ctx context.Context, topic, eventType string, data map[string]any) {
+	if data == nil {
+		eventType = ClearType
+	}
+	b.publishContext(ctx, Event{Topic: topic, Type: eventType, Data: data, Retain: true})
+}
+
+// IsRetainedClear reports whether ev clears the retained event of its topic
+// and publisher. Clears from clients that predate ClearType only have nil data.
+func IsRetainedClear(ev Event) bool {
+	return ev.Retain && (ev.Type == ClearType || ev.Data == nil)
+}
+
+// requestRetained asks the broker for the retained events matching the
+// subscription. Live events are held back until the snapshot arrives.
+func (b *BusClient) requestRetained(subID string, s *subscription) {
+	if !b.Features().Has(CapRetain) {
+		slog.DebugContext(busLogContext, "bus does not retain events, subscribing without snapshot", "module", b.id, "topic", s.topic)
+		return
+	}
+	s.hold()
+	b.publish(Event{Topic: RetainedTopic, Type: "request", Data: map[string]any{
+		"pattern": s.topic,
+		"sub":     subID,
+	}})
+	time.AfterFunc(retainedTimeout, func() { s.release(nil) })
+}
+
+// handleSnapshot hands a snapshot to the subscription that asked for it.
+func (b *BusClient) handleSnapshot(ev Event) {
+	subID, events := ParseRetainedSnapshot(ev)
+	b.mu.Lock()
+	s := b.listeners[subID]
+	b.mu.Unlock()
+	if s != nil {
+		s.release(events)
+	}
+}
+
+// NewRetainedSnapshot builds the broker's answer to a retained request.
+func NewRetainedSnapshot(subID string, events []Event) Event {
+	return Event{Topic: RetainedTopic, Type: "snapshot", Data: map[string]any{
+		"sub":    subID,
+		"events": encodeEvents(events),
+	}}
+}
+
+// ParseRetainedRequest reads a client's retained request.
+func ParseRetainedRequest(ev Event) (pattern, subID string, ok bool) {
+	if ev.Topic != RetainedTopic || ev.Type != "request" {
+		return "", "", false
+	}
+	return asString(ev.Data["pattern"]), asString(ev.Data["sub"]), true
+}
+
+// ParseRetainedSnapshot reads a snapshot built by NewRetainedSnapshot.
+func ParseRetainedSnapshot(ev Event) (subID string, events []Event) {
+	events = decodeEvents(ev.Data["events"])
+	for i := range events {
+		events[i].Retain = true
+	}
+	return asString(ev.Data["sub"]), events
+}
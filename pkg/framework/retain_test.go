@@ -0,0 +1,57 @@
+package framework
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveWithin(t *testing.T, ch <-chan Event, d time.Duration) Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(d):
+		t.Fatalf("no event within %v", d)
+		return Event{}
+	}
+}
+
+func TestWithRetainedWithoutBrokerSupport(t *testing.T) {
+	b := NewBusClient("", "mod")
+	peer := connectTestBus(t, b) // no CapRetain
+	ch, _ := b.Subscribe("state/*", WithRetained())
+	peer.send(Event{Topic: "state/lamp", Type: "update", Data: map[string]any{"on": true}})
+
+	// Live events flow at once instead of waiting for a snapshot that never comes.
+	if ev := receiveWithin(t, ch, retainedTimeout/4); ev.Topic != "state/lamp" {
+		t.Fatalf("got %+v", ev)
+	}
+	select {
+	case ev := <-peer.events:
+		if ev.Topic == RetainedTopic {
+			t.Fatalf("sent a retained request to a broker without %s", CapRetain)
+		}
+	default:
+	}
+}
+
+func TestWithRetainedReleasesLiveEventsAfterTimeout(t *testing.T) {
+	old := retainedTimeout
+	retainedTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { retainedTimeout = old })
+
+	b := NewBusClient("", "mod")
+	peer := connectTestBus(t, b, CapWildcards, CapRetain)
+	ch, _ := b.Subscribe("state/*", WithRetained())
+	peer.next(t, RetainedTopic) // the request the peer never answers
+	start := time.Now()
+	peer.send(Event{Topic: "state/lamp", Type: "update", Data: map[string]any{"on": true}})
+
+	ev := receiveWithin(t, ch, time.Second)
+	if ev.Topic != "state/lamp" {
+		t.Fatalf("got %+v", ev)
+	}
+	if held := time.Since(start); held < retainedTimeout/2 {
+		t.Fatalf("live event delivered after %v, before the snapshot timeout", held)
+	}
+}
@@ -3,13 +3,20 @@ package framework
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/trace"
+	"github.com/lms-io/module-framework/pkg/units"
 )
 
 // LifecycleHandler is the interface bundles must implement.
@@ -23,20 +30,170 @@ type RunnerConfig struct {
 	ModuleID  string
 	StateDir  string
 	BusSocket string
+	// StopTimeout bounds how long Stop and generation goroutines may take
+	// to finish before a re-init or shutdown proceeds anyway.
+	StopTimeout time.Duration
+	// ShutdownTimeout bounds how long shutdown waits for the in-flight command
+	// and for pending publishes to be flushed.
+	ShutdownTimeout time.Duration
+	// HeartbeatInterval is the period of sys/heartbeat events; 0 disables them.
+	HeartbeatInterval time.Duration
+	// WatchdogTimeout is how long a single command may run before the command
+	// loop is reported as stuck; 0 disables the watchdog.
+	WatchdogTimeout time.Duration
+	// LogFormat selects "text" (default) or "json" output.
+	LogFormat string
+	// LogLevel is the initial level ("debug", "info", "warn", "error").
+	LogLevel string
+	// LogForward publishes log records on logs/<module_id> when set.
+	LogForward bool
+	// LogForwardLevel is the minimum level forwarded to the bus (default "info").
+	LogForwardLevel string
+	// LogForwardRate caps forwarded records per second; excess records are dropped.
+	LogForwardRate float64
+	// LogBufferSize is the number of recent records kept for get_logs.
+	LogBufferSize int
+	// MetricsAddr, when set (e.g. "127.0.0.1:9100"), serves /metrics over HTTP.
+	MetricsAddr string
+	// TraceExporter selects where finished spans go: "stdout", "stderr" or
+	// "file:<path>". Trace context is propagated even when it is empty.
+	TraceExporter string
+	// BusRecord, when set, records all bus traffic to this JSONL file, or to a
+	// timestamped file inside it if it is a directory.
+	BusRecord string
+	// BusFraming is the preferred bus framing: "line" (default) or "length",
+	// which is negotiated with the broker at connect.
+	BusFraming string
+	// BusMaxFrame bounds the size of a single bus frame in bytes.
+	BusMaxFrame int
+	// BusCodec is the preferred event encoding: "json" (default) or
+	// "msgpack", which needs BusFraming "length".
+	BusCodec string
+	// BusToken authenticates the module to the broker in the handshake.
+	BusToken string
+	// BusLegacy skips the handshake when the broker is known to predate it.
+	BusLegacy bool
+	// UnitSystem, "metric" or "imperial", converts published sensor values
+	// to that system's units. Empty publishes the units modules report.
+	UnitSystem string
+	// AvailabilityTimeout marks an instance offline after this long without a
+	// state update or MarkAvailable; 0 disables it.
+	AvailabilityTimeout time.Duration
 }
 
+const (
+	defaultStopTimeout       = 10 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultWatchdogTimeout   = 2 * time.Minute
+)
+
+// Exit codes reported by Run when the module terminates.
+const (
+	ExitOK              = 0 // Clean shutdown
+	ExitStartupFailed   = 1 // Missing environment or bus unreachable
+	ExitStopFailed      = 2 // Stop returned an error or timed out
+	ExitShutdownTimeout = 3 // In-flight command or publishes did not finish in time
+	ExitBusLost         = 4 // The bus connection failed while running
+)
+
 func LoadRunnerConfig() RunnerConfig {
 	return RunnerConfig{
-		ModuleID:  os.Getenv("MODULE_ID"),
-		StateDir:  os.Getenv("STATE_DIR"),
-		BusSocket: os.Getenv("BUS_SOCKET"),
+		ModuleID:            os.Getenv("MODULE_ID"),
+		StateDir:            os.Getenv("STATE_DIR"),
+		BusSocket:           os.Getenv("BUS_SOCKET"),
+		StopTimeout:         envDuration("STOP_TIMEOUT", defaultStopTimeout),
+		ShutdownTimeout:     envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout),
+		HeartbeatInterval:   envDuration("HEARTBEAT_INTERVAL", defaultHeartbeatInterval),
+		WatchdogTimeout:     envDuration("WATCHDOG_TIMEOUT", defaultWatchdogTimeout),
+		LogFormat:           os.Getenv("LOG_FORMAT"),
+		LogLevel:            os.Getenv("LOG_LEVEL"),
+		LogForward:          envBool("LOG_FORWARD", false),
+		LogForwardLevel:     os.Getenv("LOG_FORWARD_LEVEL"),
+		LogForwardRate:      envFloat("LOG_FORWARD_RATE", defaultLogForwardRate),
+		LogBufferSize:       envInt("LOG_BUFFER_SIZE", defaultLogBufferSize),
+		MetricsAddr:         os.Getenv("METRICS_ADDR"),
+		TraceExporter:       os.Getenv("TRACE_EXPORTER"),
+		BusRecord:           os.Getenv("BUS_RECORD"),
+		BusFraming:          os.Getenv("BUS_FRAMING"),
+		BusMaxFrame:         envInt("BUS_MAX_FRAME", DefaultMaxFrameSize),
+		BusCodec:            os.Getenv("BUS_CODEC"),
+		BusToken:            os.Getenv("BUS_TOKEN"),
+		BusLegacy:           envBool("BUS_LEGACY", false),
+		UnitSystem:          os.Getenv("UNIT_SYSTEM"),
+		AvailabilityTimeout: envDuration("AVAILABILITY_TIMEOUT", 0),
+	}
+}
+
+// envDuration parses a duration such as "5s" from the environment.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid duration in environment", "key", key, "value", v, "fallback", fallback)
+		return fallback
 	}
+	return d
 }
 
+func envBool(key string, fallback bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid boolean in environment", "key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return b
+}
+
+func envInt(key string, fallback int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid integer in environment", "key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return n
+}
+
+func envFloat(key string, fallback float64) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("invalid number in environment", "key", key, "value", v, "fallback", fallback)
+		return fallback
+	}
+	return f
+}
+
+// Run starts the module and blocks until SIGINT/SIGTERM, then shuts down
+// gracefully. A non-zero exit code terminates the process.
 func Run(handler LifecycleHandler) {
+	if code := run(handler); code != ExitOK {
+		os.Exit(code)
+	}
+}
+
+func run(handler LifecycleHandler) int {
 	cfg := LoadRunnerConfig()
+	if err := configureLogging(os.Stderr, cfg); err != nil {
+		slog.Warn("invalid logging configuration, using defaults for the invalid settings", "error", err)
+	}
 	if cfg.ModuleID == "" || cfg.StateDir == "" {
-		log.Fatalf("MODULE_ID and STATE_DIR must be set")
+		slog.Error("MODULE_ID and STATE_DIR must be set")
+		return ExitStartupFailed
 	}
 
 	os.MkdirAll(cfg.StateDir, 0755)
@@ -47,185 +204,420 @@ func Run(handler LifecycleHandler) {
 		json.Unmarshal(data, &modConfig)
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer func() {
-		handler.Stop()
-		cancel()
-	}()
+	// Signals only trigger shutdown; the module context stays alive until
+	// in-flight work has drained.
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter, err := trace.ExporterFromSpec(cfg.TraceExporter)
+	if err != nil {
+		slog.Warn("tracing export disabled", "error", err)
+	}
+	var tracer *trace.Tracer
+	if exporter != nil {
+		defer exporter.Close()
+		tracer = trace.NewTracer(exporter)
+	} else {
+		tracer = trace.NewTracer(nil)
+	}
 
 	base := NewBaseModule(ctx, cfg.ModuleID, cfg.StateDir, cfg.BusSocket, modConfig)
+	base.bus.tracer = tracer
+	base.bus.SetOptions(BusOptions{Framing: cfg.BusFraming, MaxFrameSize: cfg.BusMaxFrame, Codec: cfg.BusCodec, Token: cfg.BusToken, Legacy: cfg.BusLegacy})
+	if sys, err := units.ParseSystem(cfg.UnitSystem); err != nil {
+		slog.Warn("unit conversion disabled", "error", err)
+	} else {
+		base.SetUnitSystem(sys)
+	}
+	base.SetAvailabilityTimeout(cfg.AvailabilityTimeout)
+	if cfg.BusRecord != "" {
+		rec, err := CreateRecording(cfg.BusRecord, cfg.ModuleID)
+		if err != nil {
+			slog.Warn("bus recording disabled", "path", cfg.BusRecord, "error", err)
+		} else {
+			defer rec.Close()
+			base.bus.SetRecorder(rec)
+		}
+	}
 	if err := base.Start(); err != nil {
-		log.Fatalf("Failed to start base module: %v", err)
+		slog.Error("Failed to start base module", "module", cfg.ModuleID, "error", err)
+		return ExitStartupFailed
+	}
+	attachLogForwarding(cfg.ModuleID, base.bus)
+	if f := base.Features(); f.Handshake {
+		base.log.Info("Connected to bus", "broker_version", f.BrokerVersion, "framing", f.Framing, "codec", f.Codec, "capabilities", f.Capabilities)
+	} else {
+		base.log.Info("Connected to legacy bus without handshake")
+	}
+
+	r := newRunner(cfg, cfgPath, base, handler, tracer)
+	if cfg.MetricsAddr != "" {
+		srv, err := serveMetrics(cfg.MetricsAddr, base.metrics)
+		if err != nil {
+			base.log.Error("metrics endpoint disabled", "addr", cfg.MetricsAddr, "error", err)
+		}
+		r.metricsSrv = srv
+	}
+	r.start(modConfig)
+
+	select {
+	case <-sigCtx.Done():
+		base.log.Info("Module shutting down")
+	case <-base.bus.Done():
+		base.log.Error("Bus connection lost, shutting down", "error", base.bus.Err())
+		if code := r.shutdown(); code != ExitOK {
+			return code
+		}
+		return ExitBusLost
 	}
+	return r.shutdown()
+}
+
+// runner owns the command loop of a running module.
+type runner struct {
+	cfg     RunnerConfig
+	cfgPath string
+	base    *BaseModule
+	handler LifecycleHandler
+	lc      *lifecycle
+	metrics *commandMetrics
+	tracer  *trace.Tracer
+
+	metricsSrv *http.Server
+
+	cmdSub   string
+	loopDone chan struct{}
+	// cmdCtx is the context commands run under. It is cancelled as soon as
+	// shutdown starts, so long-running commands can give up early.
+	cmdCtx       context.Context
+	stopCommands context.CancelFunc
+
+	started  time.Time
+	tracker  commandTracker
+	liveDone chan struct{} // stops heartbeat and watchdog
+}
+
+func newRunner(cfg RunnerConfig, cfgPath string, base *BaseModule, handler LifecycleHandler, tracer *trace.Tracer) *runner {
+	return &runner{
+		cfg:     cfg,
+		cfgPath: cfgPath,
+		base:    base,
+		handler: handler,
+		lc:      newLifecycle(base, handler, cfg.StopTimeout),
+		metrics: newCommandMetrics(base.metrics),
+		tracer:  tracer,
+	}
+}
+
+func (r *runner) start(modConfig map[string]any) {
+	// Subscribed on the bus directly so the command stream survives re-init.
+	ch, subID := r.base.bus.Subscribe("commands/" + r.cfg.ModuleID)
+	r.cmdSub = subID
+	r.loopDone = make(chan struct{})
+	r.cmdCtx, r.stopCommands = context.WithCancel(r.base.ctx)
+	r.started = time.Now()
+	r.liveDone = make(chan struct{})
+	go r.heartbeatLoop()
+	go r.watchdogLoop()
 
 	go func() {
+		defer close(r.loopDone)
 		if len(modConfig) == 0 {
-			base.SetBundleStatus(BundleStatus{State: StateIdling, Message: "Waiting for configuration"})
+			r.base.SetBundleStatus(BundleStatus{State: StateIdling, Message: "Waiting for configuration"})
 		} else {
-			base.SetBundleStatus(BundleStatus{State: StateReady, Message: "Initialized with saved config", Config: modConfig})
+			r.base.SetBundleStatus(BundleStatus{State: StateReady, Message: "Initialized with saved config", Config: modConfig})
 		}
 
-		// Auto-start to ensure listeners are active
-		if err := handler.Init(base); err != nil {
-			base.SetBundleStatus(BundleStatus{State: StateError, Message: "Init failed: " + err.Error()})
+		// Auto-start to ensure listeners are active. The watchdog tracks it
+		// like a command named "startup".
+		r.tracker.begin(Event{Type: "startup"})
+		if err := r.lc.Init(nil); err != nil {
+			r.initFailed(context.Background(), err)
 		}
-		if obs, ok := handler.(InstanceLifecycleObserver); ok {
-			for _, inst := range base.GetInstances() {
-				obs.OnInstanceRegistered(inst)
+		if obs, ok := r.handler.(InstanceLifecycleObserver); ok {
+			for _, inst := range r.base.GetInstances() {
+				r.base.safeCall("OnInstanceRegistered", nil, func() error {
+					obs.OnInstanceRegistered(inst)
+					return nil
+				})
 			}
 		}
+		r.tracker.end()
 
-		topic := "commands/" + cfg.ModuleID
-		ch := base.Listen(topic)
 		for {
+			var ev Event
 			select {
-			case <-ctx.Done():
+			case <-r.cmdCtx.Done():
+				return
+			case ev = <-ch:
+			}
+			if r.cmdCtx.Err() != nil {
 				return
-			case ev := <-ch:
-				log.Printf("[%s] Runner received command: %s", cfg.ModuleID, ev.Type)
-				switch ev.Type {
-				case "set_config":
-					newCfg, _ := ev.Data["config"].(map[string]any)
-					base.SetBundleStatus(BundleStatus{State: StateValidating, Message: "Validating..."})
-					if err := handler.ValidateConfig(ctx, newCfg); err != nil {
-						base.SetBundleStatus(BundleStatus{State: StateError, Message: err.Error()})
-					} else {
-						data, _ := json.MarshalIndent(newCfg, "", "  ")
-						os.WriteFile(cfgPath, data, 0644)
-						base.modConfig = newCfg
-						base.SetBundleStatus(BundleStatus{State: StateReady, Message: "Verified", Config: newCfg})
-					}
-				case "execute_init":
-					base.Info("Triggering managed initialization...")
-					if err := handler.Init(base); err != nil {
-						base.SetBundleStatus(BundleStatus{State: StateError, Message: "Init failed: " + err.Error()})
-					}
-				case "get_instances":
-					base.Publish("sys/instances_response", "instances", map[string]any{
-						"bundle": cfg.ModuleID, "instances": base.GetInstances(),
-					})
-				case "set_alias":
-					id, _ := ev.Data["id"].(string)
-					alias, _ := ev.Data["alias"].(string)
-					if id != "" {
-						insts, _ := base.im.GetInstances()
-						for _, inst := range insts {
-							if inst.ID == id {
-								inst.Alias = alias
-								base.RegisterInstance(inst) // Re-register with new alias
-								break
-							}
-						}
-					}
-				case "discover":
-					if d, ok := handler.(DeviceDiscoverer); ok {
-						go d.DiscoverDevice(ev.Data)
-					} else {
-						log.Printf("[%s] Received discover command but handler does not implement DeviceDiscoverer", cfg.ModuleID)
-					}
-				case "register_instance":
-					if ev.Data == nil {
-						break
-					}
-					payload := InstanceConfig{
-						ID:      asString(ev.Data["id"]),
-						Name:    asString(ev.Data["name"]),
-						Alias:   asString(ev.Data["alias"]),
-						Enabled: asBool(ev.Data["enabled"], true),
-					}
-					if cfgMap, ok := ev.Data["config"].(map[string]any); ok {
-						payload.Config = cfgMap
-					} else {
-						payload.Config = map[string]any{}
-					}
-					if meta, ok := ev.Data["meta"].(map[string]any); ok {
-						payload.Meta = meta
-					}
-					if raw, ok := ev.Data["raw_entities"].([]RawEntitySpec); ok {
-						payload.RawEntities = raw
-					}
-					if raw, ok := ev.Data["raw_entities"].([]any); ok {
-						data, _ := json.Marshal(raw)
-						json.Unmarshal(data, &payload.RawEntities)
-					}
-					if raw, ok := ev.Data["raw_state"].(map[string]map[string]any); ok {
-						payload.RawState = raw
-					}
-					if raw, ok := ev.Data["raw_state"].(map[string]any); ok {
-						data, _ := json.Marshal(raw)
-						json.Unmarshal(data, &payload.RawState)
-					}
-					if ents, ok := ev.Data["entities"].([]EntitySpec); ok {
-						payload.Entities = ents
-					}
-					if ents, ok := ev.Data["entities"].([]any); ok {
-						data, _ := json.Marshal(ents)
-						json.Unmarshal(data, &payload.Entities)
-					}
-					if state, ok := ev.Data["entity_state"].(map[string]map[string]any); ok {
-						payload.EntityState = state
-					}
-					if state, ok := ev.Data["entity_state"].(map[string]any); ok {
-						data, _ := json.Marshal(state)
-						json.Unmarshal(data, &payload.EntityState)
-					}
-					if p, ok := handler.(InstancePreprocessor); ok {
-						next, err := p.PrepareInstance(payload)
-						if err != nil {
-							log.Printf("[%s] register_instance preprocess failed: %v", cfg.ModuleID, err)
-							break
-						}
-						payload = next
-					}
-					if err := base.RegisterInstance(payload); err != nil {
-						log.Printf("[%s] register_instance failed: %v", cfg.ModuleID, err)
-					} else if obs, ok := handler.(InstanceLifecycleObserver); ok {
-						obs.OnInstanceRegistered(payload)
-					}
-				case "delete_instance":
-					id := asString(ev.Data["id"])
-					if id == "" {
-						break
-					}
-					log.Printf("[%s] delete_instance requested id=%s", cfg.ModuleID, id)
-					if d, ok := handler.(InstanceDeleter); ok {
-						d.DeleteInstance(id)
-					}
-					if err := base.DeleteInstance(id); err != nil {
-						log.Printf("[%s] delete_instance failed: %v", cfg.ModuleID, err)
-					} else if obs, ok := handler.(InstanceLifecycleObserver); ok {
-						log.Printf("[%s] delete_instance completed id=%s", cfg.ModuleID, id)
-						obs.OnInstanceDeleted(id)
-					} else {
-						log.Printf("[%s] delete_instance completed id=%s", cfg.ModuleID, id)
-					}
-				case "bundle_api":
-					requestID := asString(ev.Data["request_id"])
-					action := asString(ev.Data["action"])
-					params, _ := ev.Data["params"].(map[string]any)
-					resp := map[string]any{
-						"bundle":     cfg.ModuleID,
-						"request_id": requestID,
-						"action":     action,
-						"ok":         false,
-					}
-					result, err := handleBundleAPIRequest(cfg, cfgPath, base, handler, action, params)
-					if err != nil {
-						resp["error"] = err.Error()
-					} else {
-						resp["ok"] = true
-						for k, v := range result {
-							resp[k] = v
-						}
-					}
-					base.Publish("sys/bundle_api_response", "bundle_api", resp)
-				}
 			}
+			r.tracker.begin(ev)
+			start := time.Now()
+			ctx, span := r.tracer.Start(EventContext(r.cmdCtx, ev), "command "+ev.Type)
+			span.SetAttr("module", r.cfg.ModuleID)
+			span.SetAttr("request_id", asString(ev.Data["request_id"]))
+			// A panicking command is reported as a crash; the loop keeps going.
+			var result map[string]any
+			err := r.base.safeCall("command "+ev.Type, &ev, func() (err error) {
+				result, err = r.handleCommand(ctx, ev)
+				return err
+			})
+			r.ack(ctx, ev, result, err)
+			span.RecordError(err)
+			span.End()
+			r.metrics.observe(ev.Type, start, err)
+			r.tracker.end()
 		}
 	}()
+}
 
-	<-ctx.Done()
-	log.Printf("Module %s shutting down.", cfg.ModuleID)
+// initFailed reports a failed Init in the bundle status. A panic was already
+// reported as a crash, which set the status.
+func (r *runner) initFailed(ctx context.Context, err error) {
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		return
+	}
+	r.base.setBundleStatus(ctx, BundleStatus{State: StateError, Message: "Init failed: " + err.Error()})
+}
+
+// shutdown cancels the context of the in-flight command, stops accepting new
+// ones and waits for it to return, then stops the handler, flushes pending
+// publishes and closes the bus. It returns the exit code.
+func (r *runner) shutdown() int {
+	code := ExitOK
+	r.base.SetBundleStatus(BundleStatus{State: StateStopping, Message: "Shutting down"})
+	r.stopCommands()
+
+	// The cancelled command context ends the loop once the current command
+	// returns; unsubscribing stops further commands from queueing.
+	r.base.bus.Unsubscribe(r.cmdSub)
+	select {
+	case <-r.loopDone:
+	case <-time.After(r.cfg.ShutdownTimeout):
+		r.base.log.Warn("in-flight command did not finish", "timeout", r.cfg.ShutdownTimeout)
+		code = ExitShutdownTimeout
+	}
+
+	// The loop may still hold the lifecycle if it timed out, so bound Stop as well.
+	if err := callWithTimeout(r.cfg.StopTimeout, r.lc.Stop); err != nil {
+		r.base.log.Error("Stop failed", "error", err)
+		if code == ExitOK {
+			code = ExitStopFailed
+		}
+	}
+
+	close(r.liveDone)
+	r.base.markAllUnavailable("module stopped")
+
+	msg := "Stopped"
+	if code != ExitOK {
+		msg = fmt.Sprintf("Stopped with exit code %d", code)
+	}
+	r.base.SetBundleStatus(BundleStatus{State: StateStopped, Message: msg})
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownMetrics(ctx, r.metricsSrv); err != nil {
+		r.base.log.Warn("metrics endpoint shutdown failed", "error", err)
+	}
+	if err := r.base.bus.Flush(ctx); err != nil {
+		r.base.log.Error("flushing pending publishes failed", "error", err)
+		if code == ExitOK {
+			code = ExitShutdownTimeout
+		}
+	}
+	r.base.bus.Close()
+	return code
+}
+
+// handleCommand executes a single command and returns the result to include
+// in its acknowledgement.
+func (r *runner) handleCommand(ctx context.Context, ev Event) (map[string]any, error) {
+	log := r.base.log.With("command", ev.Type, "request_id", asString(ev.Data["request_id"]))
+	log.Debug("Runner received command")
+	switch ev.Type {
+	case "set_config":
+		newCfg, _ := ev.Data["config"].(map[string]any)
+		r.base.setBundleStatus(ctx, BundleStatus{State: StateValidating, Message: "Validating..."})
+		if err := r.handler.ValidateConfig(ctx, newCfg); err != nil {
+			r.base.setBundleStatus(ctx, BundleStatus{State: StateError, Message: err.Error()})
+			return nil, &CommandError{Code: ErrCodeValidation, Err: err}
+		}
+		data, _ := json.MarshalIndent(newCfg, "", "  ")
+		if err := os.WriteFile(r.cfgPath, data, 0644); err != nil {
+			return nil, err
+		}
+		r.base.modConfig = newCfg
+		r.base.setBundleStatus(ctx, BundleStatus{State: StateReady, Message: "Verified", Config: newCfg})
+		return map[string]any{"config": newCfg}, nil
+	case "execute_init":
+		r.base.Info("Triggering managed initialization...")
+		if err := r.lc.Init(&ev); err != nil {
+			r.initFailed(ctx, err)
+			return nil, err
+		}
+		return nil, nil
+	case "get_instances":
+		instances := r.base.GetInstances()
+		data, err := EncodePayload(InstancesResponse{
+			Bundle:    r.cfg.ModuleID,
+			RequestID: asString(ev.Data["request_id"]),
+			Instances: instances,
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.base.PublishContext(ctx, "sys/instances_response", "instances", data)
+		return map[string]any{"instances": instances}, nil
+	case "set_alias":
+		id, _ := ev.Data["id"].(string)
+		alias, _ := ev.Data["alias"].(string)
+		if id == "" {
+			return nil, commandErrorf(ErrCodeInvalidRequest, "missing id")
+		}
+		insts, err := r.base.im.GetInstances()
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range insts {
+			if inst.ID == id {
+				inst.Alias = alias
+				// Re-register with new alias
+				if err := r.base.registerInstance(ctx, inst); err != nil {
+					return nil, err
+				}
+				return map[string]any{"instance": inst}, nil
+			}
+		}
+		return nil, commandErrorf(ErrCodeNotFound, "instance %s not found", id)
+	case "discover":
+		d, ok := r.handler.(DeviceDiscoverer)
+		if !ok {
+			return nil, commandErrorf(ErrCodeUnsupported, "handler does not implement DeviceDiscoverer")
+		}
+		cmd := ev
+		r.base.Go(func(context.Context) {
+			r.base.safeCall("DiscoverDevice", &cmd, func() error {
+				d.DiscoverDevice(cmd.Data)
+				return nil
+			})
+		})
+		// Discovery runs asynchronously; the ack only confirms it was started.
+		return nil, nil
+	case "register_instance":
+		if ev.Data == nil {
+			return nil, commandErrorf(ErrCodeInvalidRequest, "missing instance payload")
+		}
+		payload := InstanceConfig{
+			ID:      asString(ev.Data["id"]),
+			Name:    asString(ev.Data["name"]),
+			Alias:   asString(ev.Data["alias"]),
+			Enabled: asBool(ev.Data["enabled"], true),
+		}
+		if cfgMap, ok := ev.Data["config"].(map[string]any); ok {
+			payload.Config = cfgMap
+		} else {
+			payload.Config = map[string]any{}
+		}
+		if meta, ok := ev.Data["meta"].(map[string]any); ok {
+			payload.Meta = meta
+		}
+		if raw, ok := ev.Data["raw_entities"].([]RawEntitySpec); ok {
+			payload.RawEntities = raw
+		}
+		if raw, ok := ev.Data["raw_entities"].([]any); ok {
+			data, _ := json.Marshal(raw)
+			json.Unmarshal(data, &payload.RawEntities)
+		}
+		if raw, ok := ev.Data["raw_state"].(map[string]map[string]any); ok {
+			payload.RawState = raw
+		}
+		if raw, ok := ev.Data["raw_state"].(map[string]any); ok {
+			data, _ := json.Marshal(raw)
+			json.Unmarshal(data, &payload.RawState)
+		}
+		if ents, ok := ev.Data["entities"].([]EntitySpec); ok {
+			payload.Entities = ents
+		}
+		if ents, ok := ev.Data["entities"].([]any); ok {
+			data, _ := json.Marshal(ents)
+			json.Unmarshal(data, &payload.Entities)
+		}
+		if state, ok := ev.Data["entity_state"].(map[string]map[string]any); ok {
+			payload.EntityState = state
+		}
+		if state, ok := ev.Data["entity_state"].(map[string]any); ok {
+			data, _ := json.Marshal(state)
+			json.Unmarshal(data, &payload.EntityState)
+		}
+		if p, ok := r.handler.(InstancePreprocessor); ok {
+			next, err := p.PrepareInstance(payload)
+			if err != nil {
+				return nil, &CommandError{Code: ErrCodeRejected, Err: err}
+			}
+			payload = next
+		}
+		if err := r.base.registerInstance(ctx, payload); err != nil {
+			return nil, err
+		}
+		if obs, ok := r.handler.(InstanceLifecycleObserver); ok {
+			obs.OnInstanceRegistered(payload)
+		}
+		return map[string]any{"instance": payload}, nil
+	case "delete_instance":
+		id := asString(ev.Data["id"])
+		if id == "" {
+			return nil, commandErrorf(ErrCodeInvalidRequest, "missing id")
+		}
+		log.Info("delete_instance requested", "instance", id)
+		if d, ok := r.handler.(InstanceDeleter); ok {
+			d.DeleteInstance(id)
+		}
+		if err := r.base.deleteInstance(ctx, id); err != nil {
+			return nil, err
+		}
+		log.Info("delete_instance completed", "instance", id)
+		if obs, ok := r.handler.(InstanceLifecycleObserver); ok {
+			obs.OnInstanceDeleted(id)
+		}
+		return map[string]any{"id": id}, nil
+	case "set_log_level":
+		lvl, err := parseLogLevel(asString(ev.Data["level"]))
+		if err != nil {
+			return nil, &CommandError{Code: ErrCodeInvalidRequest, Err: err}
+		}
+		logLevel.Set(lvl)
+		log.Info("log level changed", "level", lvl)
+		return map[string]any{"level": lvl.String()}, nil
+	case "bundle_api":
+		req, err := DecodePayload[BundleAPIRequest](ev)
+		if err != nil {
+			return nil, commandErrorf(ErrCodeInvalidRequest, "%v", err)
+		}
+		resp := BundleAPIResponse{Bundle: r.cfg.ModuleID, RequestID: req.RequestID, Action: req.Action}
+		result, err := handleBundleAPIRequest(ctx, r.cfg, r.cfgPath, r.base, r.handler, req.Action, req.Params)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.OK = true
+			resp.Result = result
+		}
+		data, encErr := EncodePayload(resp)
+		if encErr != nil {
+			resp.OK, resp.Result, resp.Error = false, nil, "encode result: "+encErr.Error()
+			data = mustEncode(resp)
+		}
+		r.base.PublishContext(ctx, "sys/bundle_api_response", "bundle_api", data)
+		return result, err
+	default:
+		return nil, commandErrorf(ErrCodeUnsupported, "unsupported command: %s", ev.Type)
+	}
 }
 
 func asString(v any) string {
@@ -235,6 +627,22 @@ func asString(v any) string {
 	return ""
 }
 
+// asInt accepts the numeric types produced by the bus codecs: float64 from
+// JSON and int64 or uint64 from binary codecs.
+func asInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
 func asBool(v any, fallback bool) bool {
 	if b, ok := v.(bool); ok {
 		return b
@@ -242,7 +650,7 @@ func asBool(v any, fallback bool) bool {
 	return fallback
 }
 
-func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule, handler LifecycleHandler, action string, params map[string]any) (map[string]any, error) {
+func handleBundleAPIRequest(ctx context.Context, cfg RunnerConfig, cfgPath string, base *BaseModule, handler LifecycleHandler, action string, params map[string]any) (map[string]any, error) {
 	if params == nil {
 		params = map[string]any{}
 	}
@@ -257,7 +665,7 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 		id := asString(params["id"])
 		fileType := asString(params["file_type"])
 		if id == "" {
-			return nil, fmt.Errorf("missing id")
+			return nil, commandErrorf(ErrCodeInvalidRequest, "missing id")
 		}
 		var ext string
 		switch fileType {
@@ -266,7 +674,7 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 		case "state":
 			ext = ".state.json"
 		default:
-			return nil, fmt.Errorf("unsupported file_type")
+			return nil, commandErrorf(ErrCodeInvalidRequest, "unsupported file_type")
 		}
 		targetPath := filepath.Join(cfg.StateDir, "instances", id+ext)
 		data, err := os.ReadFile(targetPath)
@@ -281,14 +689,9 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 		id := asString(params["id"])
 		content := asString(params["content"])
 		if id == "" {
-			return nil, fmt.Errorf("missing id")
-		}
-		dir := filepath.Join(cfg.StateDir, "instances")
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return nil, err
+			return nil, commandErrorf(ErrCodeInvalidRequest, "missing id")
 		}
-		targetPath := filepath.Join(dir, id+".script")
-		if err := os.WriteFile(targetPath, []byte(content), 0644); err != nil {
+		if err := base.im.SaveScript(id, content); err != nil {
 			return nil, err
 		}
 		return map[string]any{}, nil
@@ -308,6 +711,18 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 			return map[string]any{"manifest": string(data)}, nil
 		}
 		return map[string]any{"manifest": ""}, nil
+	case "get_metrics":
+		var b strings.Builder
+		if err := base.metrics.WritePrometheus(&b); err != nil {
+			return nil, err
+		}
+		return map[string]any{"format": "prometheus", "text": b.String()}, nil
+	case "get_logs":
+		n := 0 // the whole ring, as configured by LogBufferSize
+		if v, ok := asInt(params["lines"]); ok && v > 0 {
+			n = v
+		}
+		return map[string]any{"lines": logBuffer.last(n)}, nil
 	case "mcp_describe":
 		desc := MCPDescriptor{
 			Instructions: []string{
@@ -379,16 +794,16 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 				return nil, err
 			}
 			if payload.ID == "" {
-				return nil, fmt.Errorf("missing instance id")
+				return nil, commandErrorf(ErrCodeInvalidRequest, "missing instance id")
 			}
 			if p, ok := handler.(InstancePreprocessor); ok {
 				next, err := p.PrepareInstance(payload)
 				if err != nil {
-					return nil, err
+					return nil, &CommandError{Code: ErrCodeRejected, Err: err}
 				}
 				payload = next
 			}
-			if err := base.RegisterInstance(payload); err != nil {
+			if err := base.registerInstance(ctx, payload); err != nil {
 				return nil, err
 			}
 			if obs, ok := handler.(InstanceLifecycleObserver); ok {
@@ -398,12 +813,12 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 		case "instances.remove":
 			id := strings.TrimSpace(asString(args["id"]))
 			if id == "" {
-				return nil, fmt.Errorf("missing id")
+				return nil, commandErrorf(ErrCodeInvalidRequest, "missing id")
 			}
 			if d, ok := handler.(InstanceDeleter); ok {
 				d.DeleteInstance(id)
 			}
-			if err := base.DeleteInstance(id); err != nil {
+			if err := base.deleteInstance(ctx, id); err != nil {
 				return nil, err
 			}
 			if obs, ok := handler.(InstanceLifecycleObserver); ok {
@@ -422,14 +837,14 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 				newCfg = map[string]any{}
 			}
 			if err := handler.ValidateConfig(base.Context(), newCfg); err != nil {
-				return nil, err
+				return nil, &CommandError{Code: ErrCodeValidation, Err: err}
 			}
 			data, _ := json.MarshalIndent(newCfg, "", "  ")
 			if err := os.WriteFile(cfgPath, data, 0644); err != nil {
 				return nil, err
 			}
 			base.modConfig = newCfg
-			base.SetBundleStatus(BundleStatus{State: StateReady, Message: "Verified", Config: newCfg})
+			base.setBundleStatus(ctx, BundleStatus{State: StateReady, Message: "Verified", Config: newCfg})
 			return map[string]any{"ok": true, "config": newCfg}, nil
 		default:
 			if p, ok := handler.(MCPProvider); ok {
@@ -442,16 +857,16 @@ func handleBundleAPIRequest(cfg RunnerConfig, cfgPath string, base *BaseModule,
 				}
 				return out, nil
 			}
-			return nil, fmt.Errorf("unsupported tool: %s", tool)
+			return nil, commandErrorf(ErrCodeUnsupported, "unsupported tool: %s", tool)
 		}
 	default:
-		return nil, fmt.Errorf("unsupported action")
+		return nil, commandErrorf(ErrCodeUnsupported, "unsupported action")
 	}
 }
 
 func parseInstanceConfig(raw map[string]any) (InstanceConfig, error) {
 	if raw == nil {
-		return InstanceConfig{}, fmt.Errorf("missing instance")
+		return InstanceConfig{}, commandErrorf(ErrCodeInvalidRequest, "missing instance")
 	}
 	payload := InstanceConfig{
 		ID:      asString(raw["id"]),
@@ -0,0 +1,251 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/trace"
+)
+
+// scriptedHandler runs the functions it is given; nil ones succeed.
+type scriptedHandler struct {
+	validate func(ctx context.Context, config map[string]any) error
+	init     func(api ModuleAPI) error
+	stop     func() error
+}
+
+func (h *scriptedHandler) ValidateConfig(ctx context.Context, config map[string]any) error {
+	if h.validate == nil {
+		return nil
+	}
+	return h.validate(ctx, config)
+}
+
+func (h *scriptedHandler) Init(api ModuleAPI) error {
+	if h.init == nil {
+		return nil
+	}
+	return h.init(api)
+}
+
+func (h *scriptedHandler) Stop() error {
+	if h.stop == nil {
+		return nil
+	}
+	return h.stop()
+}
+
+// startTestRunner runs handler as module "mod" against a testPeer. Zero
+// timeouts in cfg default to a second.
+func startTestRunner(t *testing.T, handler LifecycleHandler, cfg RunnerConfig) (*runner, *testPeer) {
+	t.Helper()
+	cfg.ModuleID = "mod"
+	cfg.StateDir = t.TempDir()
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = time.Second
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = time.Second
+	}
+	base := NewBaseModule(context.Background(), cfg.ModuleID, cfg.StateDir, "", nil)
+	peer := connectTestBus(t, base.bus)
+	r := newRunner(cfg, filepath.Join(cfg.StateDir, "config.json"), base, handler, trace.NewTracer(nil))
+	r.start(nil)
+	return r, peer
+}
+
+func sendCommand(peer *testPeer, command, requestID string, data map[string]any) {
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["request_id"] = requestID
+	peer.send(Event{Topic: "commands/mod", Type: command, Data: data})
+}
+
+// nextAck waits for the ack of requestID.
+func nextAck(t *testing.T, peer *testPeer, requestID string) CommandAck {
+	t.Helper()
+	ev := peer.nextWhere(t, ResponseTopic("mod"), func(ev Event) bool { return ev.Data["request_id"] == requestID })
+	ack, err := DecodePayload[CommandAck](ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ack
+}
+
+func bundleState(state BundleState) func(Event) bool {
+	return func(ev Event) bool { return ev.Data["state"] == string(state) }
+}
+
+func TestShutdownCancelsAndWaitsForInFlightCommand(t *testing.T) {
+	entered := make(chan struct{})
+	stopped := make(chan struct{})
+	handler := &scriptedHandler{
+		validate: func(ctx context.Context, config map[string]any) error {
+			close(entered)
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond) // still in flight after shutdown started
+			return ctx.Err()
+		},
+		stop: func() error { close(stopped); return nil },
+	}
+	r, peer := startTestRunner(t, handler, RunnerConfig{})
+	sendCommand(peer, "set_config", "r1", map[string]any{"config": map[string]any{"host": "x"}})
+	<-entered
+
+	code := make(chan int, 1)
+	go func() { code <- r.shutdown() }()
+	peer.nextWhere(t, "sys/bundle_status", bundleState(StateStopping))
+	ack := nextAck(t, peer, "r1")
+	if ack.OK || ack.ErrorCode != ErrCodeValidation || !strings.Contains(ack.Error, "context canceled") {
+		t.Fatalf("ack = %+v, want a validation error from the cancelled context", ack)
+	}
+	if c := <-code; c != ExitOK {
+		t.Fatalf("exit code = %d, want %d", c, ExitOK)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Stop was not called")
+	}
+	// Published before the bus closed, so the flush delivered it.
+	peer.nextWhere(t, "sys/bundle_status", bundleState(StateStopped))
+}
+
+func TestShutdownTimesOutStuckCommand(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	handler := &scriptedHandler{validate: func(context.Context, map[string]any) error {
+		close(entered)
+		<-release // ignores cancellation
+		return errors.New("released")
+	}}
+	r, peer := startTestRunner(t, handler, RunnerConfig{ShutdownTimeout: 50 * time.Millisecond})
+	sendCommand(peer, "set_config", "r1", map[string]any{"config": map[string]any{}})
+	<-entered
+
+	if code := r.shutdown(); code != ExitShutdownTimeout {
+		t.Fatalf("exit code = %d, want %d", code, ExitShutdownTimeout)
+	}
+	ev := peer.nextWhere(t, "sys/bundle_status", bundleState(StateStopped))
+	if msg := asString(ev.Data["message"]); !strings.Contains(msg, "exit code 3") {
+		t.Fatalf("stopped message = %q", msg)
+	}
+}
+
+func TestShutdownReportsStopFailure(t *testing.T) {
+	cases := map[string]func() error{
+		"error": func() error { return errors.New("device busy") },
+		"deadline": func() error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	}
+	for name, stop := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, peer := startTestRunner(t, &scriptedHandler{stop: stop}, RunnerConfig{StopTimeout: 50 * time.Millisecond})
+			start := time.Now()
+			if code := r.shutdown(); code != ExitStopFailed {
+				t.Fatalf("exit code = %d, want %d", code, ExitStopFailed)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Fatalf("shutdown took %v despite the stop deadline", elapsed)
+			}
+			peer.nextWhere(t, "sys/bundle_status", bundleState(StateStopped))
+		})
+	}
+}
+
+// crashyHandler panics in its optional callbacks.
+type crashyHandler struct{ scriptedHandler }
+
+func (*crashyHandler) MCPDescribe() MCPDescriptor { return MCPDescriptor{} }
+
+func (*crashyHandler) MCPInvoke(string, map[string]any, ModuleAPI) (map[string]any, error) {
+	panic("mcp boom")
+}
+
+func (*crashyHandler) DiscoverDevice(map[string]any) { panic("discovery boom") }
+
+// eventsUntil returns the events the peer receives up to and including the
+// first one on topic for which match is true.
+func eventsUntil(t *testing.T, peer *testPeer, topic string, match func(Event) bool) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-peer.events:
+			events = append(events, ev)
+			if ev.Topic == topic && match(ev) {
+				return events
+			}
+		case <-timeout:
+			t.Fatalf("no matching event on %s", topic)
+		}
+	}
+}
+
+// checkCrash verifies a sys/crash payload.
+func checkCrash(t *testing.T, ev Event, callback, panicValue, requestID string) {
+	t.Helper()
+	if ev.Data["bundle"] != "mod" || ev.Data["callback"] != callback || ev.Data["panic"] != panicValue {
+		t.Fatalf("crash = %v, want callback %q and panic %q", ev.Data, callback, panicValue)
+	}
+	if !strings.Contains(asString(ev.Data["stack"]), "runtime/debug.Stack") {
+		t.Fatalf("crash without stack: %v", ev.Data)
+	}
+	cmd, _ := ev.Data["command"].(map[string]any)
+	if got := asString(cmd["request_id"]); got != requestID {
+		t.Fatalf("crash command request_id = %q, want %q", got, requestID)
+	}
+}
+
+// checkAlive verifies the command loop still answers after a crash.
+func checkAlive(t *testing.T, peer *testPeer) {
+	t.Helper()
+	sendCommand(peer, "get_instances", "alive", nil)
+	if ack := nextAck(t, peer, "alive"); !ack.OK {
+		t.Fatalf("command after crash failed: %+v", ack)
+	}
+}
+
+func TestInitPanicIsReportedOnce(t *testing.T) {
+	handler := &scriptedHandler{init: func(ModuleAPI) error { panic("init boom") }}
+	_, peer := startTestRunner(t, handler, RunnerConfig{})
+	checkCrash(t, peer.next(t, "sys/crash"), "Init", "init boom", "")
+
+	sendCommand(peer, "get_instances", "after", nil)
+	var statuses int
+	for _, ev := range eventsUntil(t, peer, ResponseTopic("mod"), func(ev Event) bool { return ev.Data["request_id"] == "after" }) {
+		if ev.Topic == "sys/bundle_status" && ev.Data["state"] == string(StateError) {
+			statuses++
+		}
+	}
+	if statuses != 1 {
+		t.Fatalf("published %d error statuses for one Init panic, want 1", statuses)
+	}
+}
+
+func TestCommandPanicsAreReportedAndSurvived(t *testing.T) {
+	_, peer := startTestRunner(t, &crashyHandler{}, RunnerConfig{})
+
+	sendCommand(peer, "bundle_api", "r1", map[string]any{"action": "mcp_invoke", "params": map[string]any{"tool": "lamp.blink"}})
+	checkCrash(t, peer.next(t, "sys/crash"), "command bundle_api", "mcp boom", "r1")
+	if ack := nextAck(t, peer, "r1"); ack.OK || ack.ErrorCode != ErrCodePanic {
+		t.Fatalf("ack = %+v, want a panic error", ack)
+	}
+	checkAlive(t, peer)
+
+	sendCommand(peer, "discover", "r2", map[string]any{"host": "10.0.0.5"})
+	if ack := nextAck(t, peer, "r2"); !ack.OK {
+		t.Fatalf("discover ack = %+v, want ok once started", ack)
+	}
+	checkCrash(t, peer.next(t, "sys/crash"), "DiscoverDevice", "discovery boom", "r2")
+	checkAlive(t, peer)
+}
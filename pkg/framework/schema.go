@@ -0,0 +1,237 @@
+package framework
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Abstract entity kinds.
+const (
+	KindActuator = "actuator" // Controllable: accepts commands and reports state
+	KindSensor   = "sensor"   // Read-only measurements, no commands
+	KindResource = "resource" // Anything else exposed by the device (e.g. a stream or file)
+)
+
+// AttrType is the value type of an attribute or command argument.
+type AttrType string
+
+const (
+	AttrBool   AttrType = "bool"
+	AttrInt    AttrType = "int"
+	AttrFloat  AttrType = "float"
+	AttrString AttrType = "string"
+	AttrEnum   AttrType = "enum" // a string from Enum
+)
+
+// AttributeSpec constrains one state attribute or command argument.
+type AttributeSpec struct {
+	Type AttrType `json:"type"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+	Unit string   `json:"unit,omitempty"`
+	Enum []string `json:"enum,omitempty"`
+}
+
+// CommandSpec describes a command an entity accepts.
+type CommandSpec struct {
+	Args     map[string]AttributeSpec `json:"args,omitempty"`
+	Required []string                 `json:"required,omitempty"` // args that must be present
+}
+
+// EntitySchema declares the state attributes and commands of an entity. The
+// framework checks device commands and UpdateEntityState against it.
+type EntitySchema struct {
+	Attributes map[string]AttributeSpec `json:"attributes,omitempty"`
+	Commands   map[string]CommandSpec   `json:"commands,omitempty"`
+}
+
+// WithRange returns a copy of a with Min and Max set.
+func (a AttributeSpec) WithRange(min, max float64) AttributeSpec {
+	a.Min, a.Max = &min, &max
+	return a
+}
+
+// Validate checks that the spec is well formed and fits its kind.
+func (e EntitySpec) Validate() error {
+	s := e.Schema
+	if s == nil {
+		return nil
+	}
+	if e.Kind == KindSensor && len(s.Commands) > 0 {
+		return fmt.Errorf("entity %s: sensors cannot declare commands", e.ID)
+	}
+	for name, a := range s.Attributes {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("entity %s: attribute %s: %w", e.ID, name, err)
+		}
+	}
+	for cmd, c := range s.Commands {
+		for name, a := range c.Args {
+			if err := a.validate(); err != nil {
+				return fmt.Errorf("entity %s: command %s: arg %s: %w", e.ID, cmd, name, err)
+			}
+		}
+		for _, name := range c.Required {
+			if _, ok := c.Args[name]; !ok {
+				return fmt.Errorf("entity %s: command %s: required arg %s not declared", e.ID, cmd, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (a AttributeSpec) validate() error {
+	switch a.Type {
+	case AttrBool, AttrString:
+	case AttrInt, AttrFloat:
+		if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+			return fmt.Errorf("min %v above max %v", *a.Min, *a.Max)
+		}
+	case AttrEnum:
+		if len(a.Enum) == 0 {
+			return fmt.Errorf("enum without values")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", a.Type)
+	}
+	return nil
+}
+
+// Check reports whether v is a valid value for the attribute.
+func (a AttributeSpec) Check(v any) error {
+	switch a.Type {
+	case AttrBool:
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("want bool, got %T", v)
+		}
+	case AttrString:
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("want string, got %T", v)
+		}
+	case AttrEnum:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("want one of %s, got %T", strings.Join(a.Enum, ", "), v)
+		}
+		if !slices.Contains(a.Enum, s) {
+			return fmt.Errorf("%q is not one of %s", s, strings.Join(a.Enum, ", "))
+		}
+	case AttrInt, AttrFloat:
+		f, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("want %s, got %T", a.Type, v)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("want a finite %s, got %v", a.Type, f)
+		}
+		if a.Type == AttrInt && f != math.Trunc(f) {
+			return fmt.Errorf("want int, got %v", f)
+		}
+		if a.Min != nil && f < *a.Min {
+			return fmt.Errorf("%v is below the minimum %v", f, *a.Min)
+		}
+		if a.Max != nil && f > *a.Max {
+			return fmt.Errorf("%v is above the maximum %v", f, *a.Max)
+		}
+	}
+	return nil
+}
+
+// CheckState validates a state update. Only the attributes present are
+// checked, but each must be declared.
+func (s *EntitySchema) CheckState(state map[string]any) error {
+	for _, name := range slices.Sorted(maps.Keys(state)) {
+		a, ok := s.Attributes[name]
+		if !ok {
+			return fmt.Errorf("undeclared attribute %s", name)
+		}
+		if err := a.Check(state[name]); err != nil {
+			return fmt.Errorf("attribute %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CheckCommand validates a command and its arguments. An undeclared command
+// fails with ErrCodeUnsupported, bad arguments with ErrCodeInvalidRequest.
+func (s *EntitySchema) CheckCommand(command string, args map[string]any) error {
+	c, ok := s.Commands[command]
+	if !ok {
+		return commandErrorf(ErrCodeUnsupported, "command %s not supported", command)
+	}
+	for _, name := range c.Required {
+		if _, ok := args[name]; !ok {
+			return commandErrorf(ErrCodeInvalidRequest, "command %s: missing arg %s", command, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		a, ok := c.Args[name]
+		if !ok {
+			return commandErrorf(ErrCodeInvalidRequest, "command %s: unknown arg %s", command, name)
+		}
+		if err := a.Check(args[name]); err != nil {
+			return commandErrorf(ErrCodeInvalidRequest, "command %s: arg %s: %v", command, name, err)
+		}
+	}
+	return nil
+}
+
+// validateInstance checks the entity specs of an instance and its initial
+// entity state.
+func validateInstance(inst InstanceConfig) error {
+	for _, e := range inst.Entities {
+		if err := e.Validate(); err != nil {
+			return commandErrorf(ErrCodeInvalidRequest, "instance %s: %v", inst.ID, err)
+		}
+	}
+	if err := checkEntityState(inst.Entities, inst.EntityState); err != nil {
+		return commandErrorf(ErrCodeInvalidRequest, "instance %s: %v", inst.ID, err)
+	}
+	return nil
+}
+
+// checkEntityState checks state against the schemas of entities. Entities
+// without a schema are not checked.
+func checkEntityState(entities []EntitySpec, state map[string]map[string]any) error {
+	for _, e := range entities {
+		if e.Schema == nil || state[e.ID] == nil {
+			continue
+		}
+		if err := e.Schema.CheckState(state[e.ID]); err != nil {
+			return fmt.Errorf("entity %s: %w", e.ID, err)
+		}
+	}
+	return nil
+}
+
+// entitySchema returns the schema of an entity of a registered instance, or
+// nil if it has none.
+func (m *BaseModule) entitySchema(instanceID, entityID string) *EntitySchema {
+	entities, _ := m.im.Entities(instanceID)
+	for _, e := range entities {
+		if e.ID == entityID {
+			return e.Schema
+		}
+	}
+	return nil
+}
+
+// toFloat converts any Go number to float64.
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		return 0, false
+	case rv.CanInt():
+		return float64(rv.Int()), true
+	case rv.CanUint():
+		return float64(rv.Uint()), true
+	case rv.CanFloat():
+		return rv.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,89 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func dimmerSchema() *EntitySchema {
+	level := AttributeSpec{Type: AttrInt, Unit: "%"}.WithRange(0, 100)
+	return &EntitySchema{
+		Attributes: map[string]AttributeSpec{
+			"on":         {Type: AttrBool},
+			"brightness": level,
+			"effect":     {Type: AttrEnum, Enum: []string{"none", "pulse"}},
+		},
+		Commands: map[string]CommandSpec{
+			"set_brightness": {Args: map[string]AttributeSpec{"brightness": level}, Required: []string{"brightness"}},
+		},
+	}
+}
+
+func TestEntitySchemaChecksCommands(t *testing.T) {
+	s := dimmerSchema()
+	tests := []struct {
+		command string
+		args    map[string]any
+		code    string
+	}{
+		{"set_brightness", map[string]any{"brightness": int64(40)}, ""},
+		{"set_brightness", map[string]any{"brightness": float64(40)}, ""},
+		{"set_brightness", map[string]any{"brightness": 300}, ErrCodeInvalidRequest},
+		{"set_brightness", map[string]any{"brightness": 4.5}, ErrCodeInvalidRequest},
+		{"set_brightness", map[string]any{"brightness": math.NaN()}, ErrCodeInvalidRequest},
+		{"set_brightness", map[string]any{}, ErrCodeInvalidRequest},
+		{"set_brightness", map[string]any{"brightness": 1, "speed": 2}, ErrCodeInvalidRequest},
+		{"reboot", nil, ErrCodeUnsupported},
+	}
+	for _, tt := range tests {
+		err := s.CheckCommand(tt.command, tt.args)
+		var cerr *CommandError
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("%s %v: unexpected error %v", tt.command, tt.args, err)
+		case tt.code != "" && (!errors.As(err, &cerr) || cerr.Code != tt.code):
+			t.Errorf("%s %v: got %v, want code %s", tt.command, tt.args, err, tt.code)
+		}
+	}
+}
+
+func TestUpdateEntityStateChecksSchema(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	inst := InstanceConfig{ID: "lamp", Entities: []EntitySpec{{ID: "light", Kind: KindActuator, Schema: dimmerSchema()}}}
+	if err := base.RegisterInstance(inst); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": {"on": true, "effect": "pulse"}}); err != nil {
+		t.Fatalf("valid update rejected: %v", err)
+	}
+	for _, bad := range []map[string]any{{"on": "yes"}, {"effect": "strobe"}, {"color": "red"}} {
+		if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": bad}); err == nil {
+			t.Errorf("update %v accepted", bad)
+		}
+	}
+
+	sensor := InstanceConfig{ID: "thermo", Entities: []EntitySpec{{ID: "temp", Kind: KindSensor, Schema: dimmerSchema()}}}
+	if err := base.RegisterInstance(sensor); err == nil {
+		t.Fatalf("sensor with commands registered")
+	}
+
+	// Re-registering replaces the schemas used for later updates.
+	if err := base.RegisterInstance(InstanceConfig{ID: "lamp", Entities: []EntitySpec{{ID: "light", Kind: KindActuator}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": {"on": "yes"}}); err != nil {
+		t.Fatalf("update checked against the replaced schema: %v", err)
+	}
+}
+
+func TestAttributeSpecRejectsNonFiniteNumbers(t *testing.T) {
+	for _, spec := range []AttributeSpec{{Type: AttrFloat}, {Type: AttrInt}, AttributeSpec{Type: AttrFloat}.WithRange(0, 100)} {
+		for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+			if err := spec.Check(v); err == nil {
+				t.Errorf("%s accepted %v", spec.Type, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package framework
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	retained    bool
+	excludeSelf bool
+	sources     []string
+}
+
+// WithRetained delivers the retained events matching the subscription before
+// any live event, so the subscriber starts from the last known state. It has
+// no effect if the broker does not support retained events.
+func WithRetained() SubscribeOption {
+	return func(o *subscribeOptions) { o.retained = true }
+}
+
+// ExcludeSelf drops events this module published itself, which the bus
+// otherwise echoes back. Events without a source are kept.
+func ExcludeSelf() SubscribeOption {
+	return func(o *subscribeOptions) { o.excludeSelf = true }
+}
+
+// FromSources keeps only events published by the given module IDs. Events
+// without a source are dropped.
+//
+// An empty list filters out everything: FromSources() and FromSources(ids...)
+// with an empty ids deliver nothing, so an allow-list that came up empty never
+// widens into accepting every source. Subscribe logs a warning when that
+// happens; leave the option out to accept all sources.
+func FromSources(moduleIDs ...string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if o.sources == nil {
+			o.sources = []string{}
+		}
+		o.sources = append(o.sources, moduleIDs...)
+	}
+}
@@ -0,0 +1,21 @@
+package framework
+
+import (
+	"context"
+
+	"github.com/lms-io/module-framework/pkg/trace"
+)
+
+// EventContext returns ctx carrying the trace context of ev, so work done on
+// behalf of the event (including PublishContext) joins the same trace.
+func EventContext(ctx context.Context, ev Event) context.Context {
+	if ev.TraceParent == "" {
+		return ctx
+	}
+	sc, err := trace.ParseTraceParent(ev.TraceParent)
+	if err != nil {
+		return ctx
+	}
+	sc.State = ev.TraceState
+	return trace.ContextWithSpanContext(ctx, sc)
+}
@@ -0,0 +1,143 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// TypedEvent is an event with its data decoded into T.
+type TypedEvent[T any] struct {
+	Event
+	Payload T
+}
+
+// Publisher is satisfied by ModuleAPI and BusClient.
+type Publisher interface {
+	Publish(topic, eventType string, data map[string]any)
+}
+
+// PublishTyped encodes payload into event data and publishes it.
+func PublishTyped[T any](p Publisher, topic, eventType string, payload T) error {
+	data, err := EncodePayload(payload)
+	if err != nil {
+		return err
+	}
+	p.Publish(topic, eventType, data)
+	return nil
+}
+
+// Typed decodes every event from ch into T until ctx is done or ch is
+// closed, then closes the returned channel. Events whose data does not
+// decode are logged and skipped.
+func Typed[T any](ctx context.Context, ch <-chan Event) <-chan TypedEvent[T] {
+	out := make(chan TypedEvent[T], max(cap(ch), 1))
+	go func() {
+		defer close(out)
+		for {
+			var ev Event
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				ev = e
+			}
+			payload, err := DecodePayload[T](ev)
+			if err != nil {
+				slog.Warn("dropped event with undecodable payload", "topic", ev.Topic, "type", ev.Type, "error", err)
+				continue
+			}
+			select {
+			case out <- TypedEvent[T]{Event: ev, Payload: payload}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// DecodePayload decodes ev.Data into T using T's JSON field names.
+func DecodePayload[T any](ev Event) (T, error) {
+	var payload T
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return payload, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("decode %s %s payload: %w", ev.Topic, ev.Type, err)
+	}
+	return payload, nil
+}
+
+// EncodePayload turns a struct into event data using its JSON field names.
+// Numbers come out as float64, as they would from any JSON event, except
+// integers too large for a float64 to hold exactly, which stay int64. Binary
+// codecs send whole numbers as integers on the wire.
+func EncodePayload(payload any) (map[string]any, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var data map[string]any
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("payload must encode to a JSON object: %w", err)
+	}
+	return plainNumbers(data).(map[string]any), nil
+}
+
+// mustEncode is EncodePayload for payloads made only of framework fields,
+// which always encode. Payloads carrying bundle values, such as state or
+// config maps, may hold NaN or other unencodable values and go through
+// EncodePayload or encodeOrLog instead.
+func mustEncode(payload any) map[string]any {
+	data, err := EncodePayload(payload)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// encodeOrLog is EncodePayload for callers with no error to return. Failures
+// are logged and reported as !ok so the event can be skipped.
+func (m *BaseModule) encodeOrLog(topic string, payload any) (map[string]any, bool) {
+	data, err := EncodePayload(payload)
+	if err != nil {
+		m.log.Error("dropped event with unencodable payload", "topic", topic, "error", err)
+		return nil, false
+	}
+	return data, true
+}
+
+// maxExactFloat is the largest integer below which every integer is exactly
+// representable as a float64.
+const maxExactFloat = 1 << 53
+
+// plainNumbers replaces json.Number with float64, or int64 for integers
+// beyond the exact range of float64.
+func plainNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil && (n > maxExactFloat || n < -maxExactFloat) {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, item := range v {
+			v[k] = plainNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = plainNumbers(item)
+		}
+	}
+	return v
+}
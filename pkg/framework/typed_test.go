@@ -0,0 +1,88 @@
+package framework
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type recordingPublisher struct{ events []Event }
+
+func (p *recordingPublisher) Publish(topic, eventType string, data map[string]any) {
+	p.events = append(p.events, Event{Topic: topic, Type: eventType, Data: data})
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	var p recordingPublisher
+	sent := StateUpdate{ID: "dev1", EntityState: map[string]map[string]any{"light": {"on": true, "brightness": 200}}}
+	if err := PublishTyped(&p, "state/dev1", "update", sent); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.events[0].Data["entity_state"].(map[string]any)["light"].(map[string]any)["brightness"]; got != float64(200) {
+		t.Fatalf("brightness encoded as %#v, want float64(200) as from any JSON event", got)
+	}
+	// Binary codecs still carry the whole number as an integer.
+	wire, err := MsgpackCodec.Marshal(p.events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Event
+	if err := MsgpackCodec.Unmarshal(wire, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Data["entity_state"].(map[string]any)["light"].(map[string]any)["brightness"]; got != int64(200) {
+		t.Fatalf("brightness over msgpack = %#v, want int64(200)", got)
+	}
+
+	ch := make(chan Event, 2)
+	ch <- Event{Topic: "state/dev1", Data: map[string]any{"id": 42}} // wrong type, skipped
+	ch <- p.events[0]
+	close(ch)
+	var got []TypedEvent[StateUpdate]
+	for ev := range Typed[StateUpdate](context.Background(), ch) {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Topic != "state/dev1" || got[0].Payload.ID != "dev1" {
+		t.Fatalf("got %+v", got)
+	}
+	if b := got[0].Payload.EntityState["light"]["brightness"]; b != float64(200) {
+		t.Fatalf("brightness decoded as %#v", b)
+	}
+}
+
+func TestBundleAPIResponseFlattensResult(t *testing.T) {
+	resp := BundleAPIResponse{Bundle: "hue", RequestID: "r1", Action: "get_config", OK: true, Result: map[string]any{"config": map[string]any{"host": "h"}}}
+	data, err := EncodePayload(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["request_id"] != "r1" || data["ok"] != true || data["config"] == nil {
+		t.Fatalf("data = %#v", data)
+	}
+	back, err := DecodePayload[BundleAPIResponse](Event{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, resp) {
+		t.Fatalf("round trip = %+v, want %+v", back, resp)
+	}
+}
+
+func TestUnencodableBundleValuesAreRejected(t *testing.T) {
+	base := NewBaseModule(context.Background(), "mod", t.TempDir(), "", nil)
+	if err := base.RegisterInstance(InstanceConfig{ID: "bad", Config: map[string]any{"gain": math.NaN()}}); err == nil {
+		t.Fatal("RegisterInstance accepted a NaN config")
+	}
+	if err := base.RegisterInstance(InstanceConfig{ID: "lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.UpdateEntityState("lamp", map[string]map[string]any{"light": {"level": math.Inf(1)}}); err == nil {
+		t.Fatal("UpdateEntityState accepted an infinite value")
+	}
+	if inst, err := base.im.GetInstance("lamp"); err != nil || inst.EntityState["light"] != nil {
+		t.Fatalf("unpublishable state was persisted: %+v, %v", inst.EntityState, err)
+	}
+	// Config that cannot be encoded is left out of the status instead of panicking.
+	base.SetBundleStatus(BundleStatus{State: StateReady, Config: map[string]any{"gain": math.NaN()}})
+}
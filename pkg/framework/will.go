@@ -0,0 +1,100 @@
+package framework
+
+import (
+	"context"
+	"time"
+)
+
+// Last-will topics. A client registers its will in the hello or later on
+// WillTopic, and says ByeTopic before a clean Close so the broker discards it.
+const (
+	WillTopic = "sys/will"
+	ByeTopic  = "sys/bye"
+)
+
+// CapWill means the broker publishes a client's will when its connection
+// drops without a bye.
+const CapWill = "will"
+
+// byeTimeout bounds how long Close waits for the bye to be written.
+const byeTimeout = time.Second
+
+// SetWill registers events the broker publishes on this module's behalf if
+// the connection drops without Close. Calling it again replaces the will;
+// calling it with no events removes it.
+func (b *BusClient) SetWill(events ...Event) {
+	will := make([]Event, len(events))
+	for i, ev := range events {
+		ev.Source = b.id
+		will[i] = ev
+	}
+	b.mu.Lock()
+	b.will = will
+	started := b.conn != nil
+	supported := b.features.Has(CapWill)
+	b.mu.Unlock()
+	if started && supported {
+		b.publish(Event{Topic: WillTopic, Type: "set", Data: map[string]any{"events": encodeEvents(will)}})
+	}
+}
+
+func (b *BusClient) currentWill() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.will
+}
+
+// sayBye tells the broker the disconnect is intentional.
+func (b *BusClient) sayBye() {
+	b.mu.Lock()
+	started := b.conn != nil
+	supported := b.features.Has(CapWill)
+	b.mu.Unlock()
+	if !started || !supported {
+		return
+	}
+	b.publish(Event{Topic: ByeTopic, Type: "bye"})
+	ctx, cancel := context.WithTimeout(context.Background(), byeTimeout)
+	defer cancel()
+	b.Flush(ctx)
+}
+
+// ParseWill reads the events of a will update.
+func ParseWill(ev Event) ([]Event, bool) {
+	if ev.Topic != WillTopic || ev.Type != "set" {
+		return nil, false
+	}
+	return decodeEvents(ev.Data["events"]), true
+}
+
+// updateWill registers the bundle's will: an error status listing every
+// instance as unavailable and each instance offline, published by the broker
+// if the process dies.
+func (m *BaseModule) updateWill() {
+	ids := []string{}
+	var offline []Event
+	for _, inst := range m.GetInstances() {
+		ids = append(ids, inst.ID)
+		topic := AvailabilityTopic(inst.ID)
+		data, ok := m.encodeOrLog(topic, InstanceAvailability{
+			ID:     inst.ID,
+			Bundle: m.id,
+			State:  AvailabilityOffline,
+			Reason: "module connection lost",
+		})
+		if ok {
+			offline = append(offline, Event{Topic: topic, Type: "availability", Retain: true, Data: data})
+		}
+	}
+	data, ok := m.encodeOrLog("sys/bundle_status", BundleStatusPayload{
+		Bundle:      m.id,
+		State:       StateError,
+		Message:     "Module connection lost",
+		Unavailable: ids,
+	})
+	if !ok {
+		return
+	}
+	status := Event{Topic: "sys/bundle_status", Type: "status", Retain: true, Data: data}
+	m.bus.SetWill(append([]Event{status}, offline...)...)
+}
@@ -0,0 +1,92 @@
+package kinds
+
+import (
+	"github.com/lms-io/module-framework/pkg/framework"
+	"github.com/lms-io/module-framework/pkg/units"
+)
+
+var (
+	onOff   = framework.AttributeSpec{Type: framework.AttrBool}
+	percent = framework.AttributeSpec{Type: framework.AttrInt, Unit: "%"}.WithRange(0, 100)
+	kelvin  = framework.AttributeSpec{Type: framework.AttrInt, Unit: units.Kelvin}.WithRange(1000, 10000)
+	celsius = framework.AttributeSpec{Type: framework.AttrFloat, Unit: units.Celsius}
+	setback = framework.AttributeSpec{Type: framework.AttrFloat, Unit: units.Celsius}.WithRange(5, 35)
+	hvac    = framework.AttributeSpec{Type: framework.AttrEnum, Enum: HVACModes}
+)
+
+// noArgs is a command without arguments.
+var noArgs = framework.CommandSpec{}
+
+// withArg is a command with one required argument.
+func withArg(name string, spec framework.AttributeSpec) framework.CommandSpec {
+	return framework.CommandSpec{Args: map[string]framework.AttributeSpec{name: spec}, Required: []string{name}}
+}
+
+func init() {
+	Register(Kind{Name: Switch, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{AttrOn: onOff},
+		Commands:   map[string]framework.CommandSpec{CmdTurnOn: noArgs, CmdTurnOff: noArgs, CmdToggle: noArgs},
+	}})
+	Register(Kind{Name: Light, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{AttrOn: onOff, AttrBrightness: percent, AttrColorTemp: kelvin},
+		Commands: map[string]framework.CommandSpec{
+			CmdTurnOn:        {Args: map[string]framework.AttributeSpec{AttrBrightness: percent, AttrColorTemp: kelvin}},
+			CmdTurnOff:       noArgs,
+			CmdToggle:        noArgs,
+			CmdSetBrightness: withArg(AttrBrightness, percent),
+			CmdSetColorTemp:  withArg(AttrColorTemp, kelvin),
+		},
+	}})
+	Register(Kind{Name: Cover, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{
+			AttrPosition:   percent,
+			AttrCoverState: {Type: framework.AttrEnum, Enum: CoverStates},
+		},
+		Commands: map[string]framework.CommandSpec{
+			CmdOpen: noArgs, CmdClose: noArgs, CmdStop: noArgs,
+			CmdSetPosition: withArg(AttrPosition, percent),
+		},
+	}})
+	Register(Kind{Name: Climate, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{
+			AttrCurrentTemperature: celsius,
+			AttrTargetTemperature:  setback,
+			AttrHVACMode:           hvac,
+		},
+		Commands: map[string]framework.CommandSpec{
+			CmdSetTemperature: withArg(AttrTargetTemperature, setback),
+			CmdSetHVACMode:    withArg(AttrHVACMode, hvac),
+		},
+	}})
+	Register(Kind{Name: Fan, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{AttrOn: onOff, AttrSpeed: percent},
+		Commands: map[string]framework.CommandSpec{
+			CmdTurnOn: noArgs, CmdTurnOff: noArgs, CmdToggle: noArgs,
+			CmdSetSpeed: withArg(AttrSpeed, percent),
+		},
+	}})
+	Register(Kind{Name: Lock, Abstract: framework.KindActuator, Schema: framework.EntitySchema{
+		Attributes: map[string]framework.AttributeSpec{AttrLocked: onOff},
+		Commands:   map[string]framework.CommandSpec{CmdLock: noArgs, CmdUnlock: noArgs},
+	}})
+	Register(Kind{Name: Sensor, Abstract: framework.KindSensor})
+	Register(Kind{Name: BinarySensor, Abstract: framework.KindSensor})
+
+	measure := func(name, unit string) {
+		RegisterDeviceClass(DeviceClass{Name: name, Kind: Sensor, Value: framework.AttributeSpec{Type: framework.AttrFloat, Unit: unit}})
+	}
+	measure("temperature", units.Celsius)
+	measure("humidity", "%")
+	measure("illuminance", "lx")
+	measure("pressure", units.Hectopascal)
+	measure("power", units.Watt)
+	measure("energy", units.KilowattHour)
+	measure("voltage", "V")
+	measure("current", "A")
+	measure("battery", "%")
+	measure("co2", "ppm")
+
+	for _, name := range []string{"motion", "occupancy", "door", "window", "smoke", "moisture", "connectivity"} {
+		RegisterDeviceClass(DeviceClass{Name: name, Kind: BinarySensor, Value: onOff})
+	}
+}
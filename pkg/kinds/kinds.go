@@ -0,0 +1,264 @@
+// Package kinds is the registry of standard entity kinds: their attribute
+// names, commands and sensor device classes. Bundles build EntitySpecs and
+// state maps from it so every bundle reports a light or a thermostat the
+// same way.
+package kinds
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+	"github.com/lms-io/module-framework/pkg/units"
+)
+
+// Standard kinds.
+const (
+	Switch       = "switch"
+	Light        = "light"
+	Cover        = "cover"
+	Climate      = "climate"
+	Fan          = "fan"
+	Lock         = "lock"
+	Sensor       = "sensor"
+	BinarySensor = "binary_sensor"
+)
+
+// Standard attribute names.
+const (
+	AttrOn                 = "on"
+	AttrBrightness         = "brightness"  // percent
+	AttrColorTemp          = "color_temp"  // kelvin
+	AttrPosition           = "position"    // percent open
+	AttrCoverState         = "cover_state" // see CoverStates
+	AttrCurrentTemperature = "current_temperature"
+	AttrTargetTemperature  = "target_temperature"
+	AttrHVACMode           = "hvac_mode" // see HVACModes
+	AttrSpeed              = "speed"     // percent
+	AttrLocked             = "locked"
+	AttrValue              = "value" // the reading of a sensor or binary sensor
+)
+
+// Standard commands.
+const (
+	CmdTurnOn         = "turn_on"
+	CmdTurnOff        = "turn_off"
+	CmdToggle         = "toggle"
+	CmdSetBrightness  = "set_brightness"
+	CmdSetColorTemp   = "set_color_temp"
+	CmdOpen           = "open"
+	CmdClose          = "close"
+	CmdStop           = "stop"
+	CmdSetPosition    = "set_position"
+	CmdSetTemperature = "set_temperature"
+	CmdSetHVACMode    = "set_hvac_mode"
+	CmdSetSpeed       = "set_speed"
+	CmdLock           = "lock"
+	CmdUnlock         = "unlock"
+)
+
+// Enumerated attribute values.
+var (
+	CoverStates = []string{"open", "closed", "opening", "closing", "stopped"}
+	HVACModes   = []string{"off", "heat", "cool", "auto", "fan_only", "dry"}
+)
+
+// Kind is a standard entity kind.
+type Kind struct {
+	Name     string                 // e.g. Light
+	Abstract string                 // framework.KindActuator, KindSensor or KindResource
+	Schema   framework.EntitySchema // attributes and commands; sensors get theirs from a DeviceClass
+}
+
+// DeviceClass says what a sensor or binary sensor measures.
+type DeviceClass struct {
+	Name  string
+	Kind  string                  // Sensor or BinarySensor
+	Value framework.AttributeSpec // type, unit and range of AttrValue
+}
+
+var (
+	mu      sync.RWMutex
+	kinds   = map[string]Kind{}
+	classes = map[string]DeviceClass{}
+)
+
+// Register adds or replaces a kind, so bundles can extend the registry.
+func Register(k Kind) {
+	mu.Lock()
+	defer mu.Unlock()
+	kinds[k.Name] = k
+}
+
+// RegisterDeviceClass adds or replaces a device class.
+func RegisterDeviceClass(c DeviceClass) {
+	mu.Lock()
+	defer mu.Unlock()
+	classes[c.Name] = c
+}
+
+// Lookup returns a registered kind.
+func Lookup(name string) (Kind, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	k, ok := kinds[name]
+	return k, ok
+}
+
+// LookupDeviceClass returns a registered device class.
+func LookupDeviceClass(name string) (DeviceClass, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := classes[name]
+	return c, ok
+}
+
+// Names lists the registered kinds in order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return slices.Sorted(maps.Keys(kinds))
+}
+
+// DeviceClasses lists the registered device classes of a kind in order.
+func DeviceClasses(kind string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var names []string
+	for name, c := range classes {
+		if c.Kind == kind {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// EntityOption adjusts the spec built by Entity.
+type EntityOption func(*framework.EntitySpec)
+
+// WithUnit makes unit the native unit of every attribute and command arg
+// measured in the same dimension, converting their range bounds along, e.g.
+// WithUnit(units.Fahrenheit) for a thermostat that reports °F. Specs of
+// other dimensions, and color temperatures in kelvin, are left alone.
+func WithUnit(unit string) EntityOption {
+	return func(spec *framework.EntitySpec) {
+		if spec.Schema == nil {
+			return
+		}
+		for name, a := range spec.Schema.Attributes {
+			spec.Schema.Attributes[name] = inUnit(a, unit)
+		}
+		for _, c := range spec.Schema.Commands {
+			for name, a := range c.Args {
+				c.Args[name] = inUnit(a, unit)
+			}
+		}
+	}
+}
+
+// inUnit converts a to unit if both measure the same dimension. Kelvin is
+// kept, as units.System.Preferred does: it measures color temperature.
+func inUnit(a framework.AttributeSpec, unit string) framework.AttributeSpec {
+	if a.Unit == "" || a.Unit == unit || a.Unit == units.Kelvin {
+		return a
+	}
+	if _, err := units.Convert(0, a.Unit, unit); err != nil {
+		return a
+	}
+	bound := func(p *float64) *float64 {
+		if p == nil {
+			return nil
+		}
+		v, _ := units.Convert(*p, a.Unit, unit)
+		v = math.Round(v*1e6) / 1e6 // hide floating point noise
+		return &v
+	}
+	a.Min, a.Max, a.Unit = bound(a.Min), bound(a.Max), unit
+	return a
+}
+
+// Entity builds the spec of an entity of a standard kind. Its attributes use
+// the kind's default units unless changed with WithUnit.
+func Entity(kind, id, name string, opts ...EntityOption) (framework.EntitySpec, error) {
+	k, ok := Lookup(kind)
+	if !ok {
+		return framework.EntitySpec{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	spec := framework.EntitySpec{ID: id, Kind: k.Abstract, Name: name, Class: k.Name}
+	// A kind without attributes, like a sensor without device class, is not checked.
+	if len(k.Schema.Attributes) > 0 || len(k.Schema.Commands) > 0 {
+		spec.Schema = cloneSchema(k.Schema)
+	}
+	for _, opt := range opts {
+		opt(&spec)
+	}
+	return spec, nil
+}
+
+// ClimateEntity builds the spec of a climate entity whose temperatures are
+// in unit, e.g. units.Fahrenheit. An empty unit keeps the default °C.
+func ClimateEntity(id, name, unit string) (framework.EntitySpec, error) {
+	if unit == "" {
+		return Entity(Climate, id, name)
+	}
+	return Entity(Climate, id, name, WithUnit(unit))
+}
+
+// SensorEntity builds the spec of a sensor or binary sensor of a device class.
+// unit overrides the class's default unit when not empty; a range is
+// converted to it when the units share a dimension.
+func SensorEntity(deviceClass, id, name, unit string) (framework.EntitySpec, error) {
+	c, ok := LookupDeviceClass(deviceClass)
+	if !ok {
+		return framework.EntitySpec{}, fmt.Errorf("unknown device class %q", deviceClass)
+	}
+	spec, err := Entity(c.Kind, id, name)
+	if err != nil {
+		return spec, err
+	}
+	value := c.Value
+	if unit != "" {
+		value = inUnit(value, unit)
+		value.Unit = unit
+	}
+	spec.DeviceClass = c.Name
+	spec.Schema = &framework.EntitySchema{Attributes: map[string]framework.AttributeSpec{AttrValue: value}}
+	return spec, nil
+}
+
+// MustEntity is Entity for kinds known to be registered. It panics otherwise.
+func MustEntity(kind, id, name string, opts ...EntityOption) framework.EntitySpec {
+	spec, err := Entity(kind, id, name, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// State checks attrs against the entity's schema and returns them in the
+// shape UpdateEntityState takes.
+func State(spec framework.EntitySpec, attrs map[string]any) (map[string]map[string]any, error) {
+	if spec.Schema != nil {
+		if err := spec.Schema.CheckState(attrs); err != nil {
+			return nil, fmt.Errorf("entity %s: %w", spec.ID, err)
+		}
+	}
+	return map[string]map[string]any{spec.ID: attrs}, nil
+}
+
+func cloneSchema(s framework.EntitySchema) *framework.EntitySchema {
+	c := framework.EntitySchema{
+		Attributes: maps.Clone(s.Attributes),
+		Commands:   make(map[string]framework.CommandSpec, len(s.Commands)),
+	}
+	for name, cmd := range s.Commands {
+		cmd.Args = maps.Clone(cmd.Args)
+		cmd.Required = slices.Clone(cmd.Required)
+		c.Commands[name] = cmd
+	}
+	return &c
+}
@@ -0,0 +1,94 @@
+package kinds
+
+import (
+	"testing"
+
+	"github.com/lms-io/module-framework/pkg/framework"
+	"github.com/lms-io/module-framework/pkg/units"
+)
+
+func TestBuiltinKindsAreValid(t *testing.T) {
+	for _, name := range Names() {
+		spec, err := Entity(name, "e1", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, kind := range []string{Sensor, BinarySensor} {
+		for _, class := range DeviceClasses(kind) {
+			spec, err := SensorEntity(class, "e1", class, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec.Kind != framework.KindSensor || spec.Class != kind || spec.DeviceClass != class {
+				t.Errorf("%s: spec = %+v", class, spec)
+			}
+			if err := spec.Validate(); err != nil {
+				t.Errorf("%s: %v", class, err)
+			}
+		}
+	}
+}
+
+func TestStateConformsToKind(t *testing.T) {
+	light := MustEntity(Light, "ceiling", "Ceiling")
+	state, err := State(light, map[string]any{AttrOn: true, AttrBrightness: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["ceiling"][AttrBrightness] != 80 {
+		t.Fatalf("state = %v", state)
+	}
+	if _, err := State(light, map[string]any{"power": true}); err == nil {
+		t.Fatalf("non-standard attribute accepted")
+	}
+
+	// Entities get their own schema, so changing one leaves the registry alone.
+	delete(light.Schema.Commands, CmdToggle)
+	if _, ok := MustEntity(Light, "other", "Other").Schema.Commands[CmdToggle]; !ok {
+		t.Fatalf("registry schema modified through an entity")
+	}
+
+	temp, err := SensorEntity("temperature", "t1", "Temperature", "°F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp.Schema.Attributes[AttrValue].Unit != "°F" {
+		t.Fatalf("unit override ignored: %+v", temp.Schema.Attributes[AttrValue])
+	}
+}
+
+func TestClimateEntityInFahrenheit(t *testing.T) {
+	spec, err := ClimateEntity("thermo", "Thermostat", units.Fahrenheit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	target := spec.Schema.Attributes[AttrTargetTemperature]
+	if target.Unit != units.Fahrenheit || *target.Min != 41 || *target.Max != 95 {
+		t.Fatalf("target = %+v, want °F with range 41-95", target)
+	}
+	if _, err := State(spec, map[string]any{AttrCurrentTemperature: 70.5, AttrTargetTemperature: 68}); err != nil {
+		t.Fatalf("°F state rejected: %v", err)
+	}
+	if err := spec.Schema.CheckCommand(CmdSetTemperature, map[string]any{AttrTargetTemperature: 68}); err != nil {
+		t.Fatalf("°F set_temperature rejected: %v", err)
+	}
+	if err := spec.Schema.CheckCommand(CmdSetTemperature, map[string]any{AttrTargetTemperature: 100}); err == nil {
+		t.Fatal("set_temperature above the converted range accepted")
+	}
+
+	// Only specs of the same dimension change, and the registry stays in °C.
+	light := MustEntity(Light, "ceiling", "Ceiling", WithUnit(units.Fahrenheit))
+	if light.Schema.Attributes[AttrColorTemp].Unit != units.Kelvin {
+		t.Fatalf("color_temp converted: %+v", light.Schema.Attributes[AttrColorTemp])
+	}
+	if c := MustEntity(Climate, "c", "C").Schema.Attributes[AttrTargetTemperature]; c.Unit != units.Celsius || *c.Max != 35 {
+		t.Fatalf("registry schema modified: %+v", c)
+	}
+}
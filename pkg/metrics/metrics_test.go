@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("bus_events_published_total", "Events published.", "prefix")
+	c.Inc("state")
+	c.Add(2, "state")
+	c.Inc("sys")
+	r.Gauge("instance_files", "Files on disk.", "kind").Set(3, "instance")
+	h := r.Histogram("write_seconds", "Write latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	r.Counter("escaped_total", "Line\nbreak.", "v").Inc(`a"b`)
+
+	var b strings.Builder
+	if err := r.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP bus_events_published_total Events published.
+# TYPE bus_events_published_total counter
+bus_events_published_total{prefix="state"} 3
+bus_events_published_total{prefix="sys"} 1
+# HELP escaped_total Line\nbreak.
+# TYPE escaped_total counter
+escaped_total{v="a\"b"} 1
+# HELP instance_files Files on disk.
+# TYPE instance_files gauge
+instance_files{kind="instance"} 3
+# HELP write_seconds Write latency.
+# TYPE write_seconds histogram
+write_seconds_bucket{le="0.1"} 1
+write_seconds_bucket{le="1"} 2
+write_seconds_bucket{le="+Inf"} 2
+write_seconds_sum 0.55
+write_seconds_count 2
+`
+	if b.String() != want {
+		t.Fatalf("exposition mismatch:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryReturnsExistingMetric(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits_total", "", "path").Inc("/a")
+	r.Counter("hits_total", "", "path").Inc("/a")
+
+	var b strings.Builder
+	r.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `hits_total{path="/a"} 2`) {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	// A conflicting registration is detached: usable, but never exposed.
+	g := r.Gauge("hits_total", "", "path")
+	g.Set(7, "/a")
+	b.Reset()
+	r.WritePrometheus(&b)
+	if out := b.String(); !strings.Contains(out, "# TYPE hits_total counter") || strings.Contains(out, " 7") {
+		t.Fatalf("conflicting gauge leaked into output:\n%s", out)
+	}
+}
+
+func TestLabelCountMismatchIsDropped(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("hits_total", "", "path")
+	c.Inc()
+	c.Inc("/a", "extra")
+	c.Inc("/a")
+
+	var b strings.Builder
+	r.WritePrometheus(&b)
+	if out := b.String(); !strings.Contains(out, `hits_total{path="/a"} 1`) || strings.Count(out, "hits_total{") != 1 {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
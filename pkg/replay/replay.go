@@ -0,0 +1,137 @@
+// Package replay feeds a bus recording back into a module, at the original
+// pace or accelerated, so bundles can be regression-tested against captured
+// sessions.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/broker"
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+// Sink receives each replayed event.
+type Sink func(framework.Event) error
+
+// BrokerSink injects events into a broker, reaching every connected module
+// whether it uses a socket or an in-process pipe.
+func BrokerSink(b *broker.Broker) Sink {
+	return func(ev framework.Event) error {
+		b.Publish(ev)
+		return nil
+	}
+}
+
+// ClientSink publishes events through a client connected to an existing bus,
+// keeping their source and retain flag. A broker that knows the client from
+// the handshake stamps the client's module ID as the source instead.
+func ClientSink(c *framework.BusClient) Sink {
+	return func(ev framework.Event) error {
+		c.PublishEvent(ev)
+		return nil
+	}
+}
+
+// Player replays the events a module received.
+type Player struct {
+	// Speed scales the recorded gaps: 1 is real time, 10 is ten times faster
+	// and 0 or less replays without delay.
+	Speed float64
+	// IncludeEchoes also replays inbound copies of the module's own publishes.
+	// They are skipped by default because the module re-publishes them itself.
+	IncludeEchoes bool
+}
+
+// Load reads a recording file.
+func Load(path string) ([]framework.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return framework.ReadRecording(f)
+}
+
+// Inbound returns the events the module received, in order. Unless
+// includeEchoes is set, an inbound event identical to an earlier outbound one
+// is treated as the bus echo of that publish and dropped.
+//
+// A retained snapshot answered a subscription of the recording client, which
+// does not exist in the replaying one; its events are returned one by one as
+// retained events instead.
+func Inbound(records []framework.Record, includeEchoes bool) []framework.Record {
+	pending := map[string]int{}
+	var out []framework.Record
+	for _, rec := range records {
+		switch rec.Direction {
+		case framework.DirOut:
+			pending[eventKey(rec.Event)]++
+		case framework.DirIn:
+			for _, ev := range unpack(rec.Event) {
+				key := eventKey(ev)
+				if !includeEchoes && pending[key] > 0 {
+					pending[key]--
+					continue
+				}
+				out = append(out, framework.Record{Time: rec.Time, Direction: rec.Direction, Event: ev})
+			}
+		}
+	}
+	return out
+}
+
+// unpack returns the retained events of a snapshot, or ev itself.
+func unpack(ev framework.Event) []framework.Event {
+	if ev.Topic != framework.RetainedTopic || ev.Type != "snapshot" {
+		return []framework.Event{ev}
+	}
+	_, events := framework.ParseRetainedSnapshot(ev)
+	return events
+}
+
+func eventKey(ev framework.Event) string {
+	ev.TraceParent, ev.TraceState = "", ""
+	data, _ := json.Marshal(ev)
+	return string(data)
+}
+
+// Play sends the inbound events of records to sink, preserving their relative
+// timing scaled by Speed. It stops early if ctx is cancelled.
+func (p Player) Play(ctx context.Context, records []framework.Record, sink Sink) error {
+	events := Inbound(records, p.IncludeEchoes)
+	if len(events) == 0 {
+		return nil
+	}
+	start := time.Now()
+	first := events[0].Time
+	for i, rec := range events {
+		if p.Speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / p.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sink(rec.Event); err != nil {
+			return fmt.Errorf("event %d (%s): %w", i, rec.Event.Topic, err)
+		}
+	}
+	return nil
+}
+
+// WriteSummary prints one line per inbound event, for inspecting a recording.
+func WriteSummary(w io.Writer, records []framework.Record, includeEchoes bool) {
+	for _, rec := range Inbound(records, includeEchoes) {
+		fmt.Fprintf(w, "%s %s %s\n", rec.Time.Format(time.RFC3339Nano), rec.Event.Topic, rec.Event.Type)
+	}
+}
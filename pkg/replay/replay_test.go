@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lms-io/module-framework/pkg/broker"
+	"github.com/lms-io/module-framework/pkg/framework"
+)
+
+func TestPlaySkipsEchoesOfOwnPublishes(t *testing.T) {
+	t0 := time.Now()
+	cmd := framework.Event{Topic: "commands/mod", Type: "refresh"}
+	state := framework.Event{Topic: "state/dev1", Type: "update", Data: map[string]any{"on": true}}
+	other := framework.Event{Topic: "state/dev2", Type: "update"}
+	records := []framework.Record{
+		{Time: t0, Direction: framework.DirIn, Event: cmd},
+		{Time: t0.Add(10 * time.Millisecond), Direction: framework.DirOut, Event: state},
+		{Time: t0.Add(11 * time.Millisecond), Direction: framework.DirIn, Event: state},
+		{Time: t0.Add(20 * time.Millisecond), Direction: framework.DirIn, Event: other},
+	}
+
+	var got []string
+	err := Player{}.Play(context.Background(), records, func(ev framework.Event) error {
+		got = append(got, ev.Topic)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "commands/mod" || got[1] != "state/dev2" {
+		t.Fatalf("replayed %v", got)
+	}
+
+	if n := len(Inbound(records, true)); n != 3 {
+		t.Fatalf("Inbound with echoes returned %d records, want 3", n)
+	}
+}
+
+func TestInboundUnpacksRetainedSnapshots(t *testing.T) {
+	lamp := framework.Event{Topic: "state/lamp", Type: "update", Source: "hue", Data: map[string]any{"on": true}}
+	snapshot := framework.NewRetainedSnapshot("sub-1", []framework.Event{lamp})
+	records := []framework.Record{{Time: time.Now(), Direction: framework.DirIn, Event: snapshot}}
+
+	got := Inbound(records, false)
+	if len(got) != 1 {
+		t.Fatalf("Inbound returned %d records, want the snapshot's one event", len(got))
+	}
+	if ev := got[0].Event; ev.Topic != "state/lamp" || ev.Source != "hue" || !ev.Retain {
+		t.Fatalf("replayed %+v, want hue's retained state/lamp", ev)
+	}
+}
+
+func TestClientSinkKeepsSourceAndRetain(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	watch := framework.NewBusClient("", "watch")
+	if err := watch.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+	events, _ := watch.Subscribe("state/*")
+
+	// Without a handshake the broker keeps the event's own source.
+	c := framework.NewBusClient("", "busreplay")
+	c.SetOptions(framework.BusOptions{Legacy: true})
+	if err := c.StartConn(b.Pipe()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ClientSink(c)(framework.Event{Topic: "state/lamp", Type: "update", Source: "hue", Retain: true, Data: map[string]any{"on": true}})
+
+	select {
+	case ev := <-events:
+		if ev.Source != "hue" || !ev.Retain {
+			t.Fatalf("got %+v, want hue's retained event", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event not replayed")
+	}
+}
@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// WriterExporter writes each finished span as a JSON line, for local debugging.
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter appends spans to the file at path.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+func (e *WriterExporter) ExportSpan(s SpanData) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.w.Write(append(data, '\n'))
+	e.mu.Unlock()
+}
+
+// Close closes the underlying file, if the exporter opened one.
+func (e *WriterExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// ExporterFromSpec builds an exporter from "stdout", "stderr" or "file:<path>".
+// An empty spec returns a nil exporter.
+func ExporterFromSpec(spec string) (*WriterExporter, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case spec == "stderr":
+		return NewWriterExporter(os.Stderr), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileExporter(strings.TrimPrefix(spec, "file:"))
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", spec)
+	}
+}
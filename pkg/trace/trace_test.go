@@ -0,0 +1,53 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.TraceParent(); got != tp {
+		t.Fatalf("TraceParent()=%q want %q", got, tp)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Fatalf("ParseTraceParent(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestChildSpanSharesTrace(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTracer(NewWriterExporter(&buf))
+
+	ctx, root := tr.Start(context.Background(), "command")
+	_, child := tr.Start(ctx, "publish")
+	child.End()
+	root.End()
+
+	dec := json.NewDecoder(&buf)
+	var c, r SpanData
+	if err := dec.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || r.ParentSpanID != "" {
+		t.Fatalf("unexpected span relationship: child=%+v root=%+v", c, r)
+	}
+}
@@ -0,0 +1,180 @@
+// Package units converts sensor values between units of the same dimension
+// and picks the unit a unit system prefers for a dimension.
+package units
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Units known to the converter. Symbols are what bundles put in
+// AttributeSpec.Unit.
+const (
+	Celsius    = "°C"
+	Fahrenheit = "°F"
+	Kelvin     = "K"
+
+	Watt     = "W"
+	Kilowatt = "kW"
+
+	WattHour     = "Wh"
+	KilowattHour = "kWh"
+
+	Pascal        = "Pa"
+	Hectopascal   = "hPa"
+	Bar           = "bar"
+	PSI           = "psi"
+	InchesMercury = "inHg"
+
+	Millimeter = "mm"
+	Centimeter = "cm"
+	Meter      = "m"
+	Kilometer  = "km"
+	Inch       = "in"
+	Foot       = "ft"
+	Mile       = "mi"
+
+	MetersPerSecond   = "m/s"
+	KilometersPerHour = "km/h"
+	MilesPerHour      = "mph"
+
+	Liter      = "L"
+	CubicMeter = "m³"
+	Gallon     = "gal" // US gallon
+	CubicFoot  = "ft³"
+	Gram       = "g"
+	Kilogram   = "kg"
+	Ounce      = "oz"
+	Pound      = "lb"
+)
+
+// Dimensions group units that convert into each other.
+const (
+	Temperature = "temperature"
+	Power       = "power"
+	Energy      = "energy"
+	Pressure    = "pressure"
+	Length      = "length"
+	Speed       = "speed"
+	Volume      = "volume"
+	Mass        = "mass"
+)
+
+// System is a unit system modules can normalize to.
+type System string
+
+const (
+	Native   System = ""         // keep the units modules report
+	Metric   System = "metric"   // °C, hPa, m, km/h, L, kg
+	Imperial System = "imperial" // °F, inHg, ft, mph, gal, lb
+)
+
+// ParseSystem reads a unit system name; empty means Native.
+func ParseSystem(s string) (System, error) {
+	switch sys := System(strings.ToLower(strings.TrimSpace(s))); sys {
+	case Native, Metric, Imperial:
+		return sys, nil
+	}
+	return Native, fmt.Errorf("unknown unit system %q", s)
+}
+
+// unit converts to the base unit of its dimension as base = v*scale + offset.
+type unit struct {
+	dim    string
+	scale  float64
+	offset float64
+}
+
+var table = map[string]unit{
+	Celsius:    {Temperature, 1, 0},
+	Fahrenheit: {Temperature, 5.0 / 9, -32 * 5.0 / 9},
+	Kelvin:     {Temperature, 1, -273.15},
+
+	Watt:     {Power, 1, 0},
+	Kilowatt: {Power, 1000, 0},
+
+	WattHour:     {Energy, 1, 0},
+	KilowattHour: {Energy, 1000, 0},
+
+	Pascal:        {Pressure, 1, 0},
+	Hectopascal:   {Pressure, 100, 0},
+	Bar:           {Pressure, 100000, 0},
+	PSI:           {Pressure, 6894.757293168, 0},
+	InchesMercury: {Pressure, 3386.389, 0},
+
+	Millimeter: {Length, 0.001, 0},
+	Centimeter: {Length, 0.01, 0},
+	Meter:      {Length, 1, 0},
+	Kilometer:  {Length, 1000, 0},
+	Inch:       {Length, 0.0254, 0},
+	Foot:       {Length, 0.3048, 0},
+	Mile:       {Length, 1609.344, 0},
+
+	MetersPerSecond:   {Speed, 1, 0},
+	KilometersPerHour: {Speed, 1 / 3.6, 0},
+	MilesPerHour:      {Speed, 0.44704, 0},
+
+	Liter:      {Volume, 1, 0},
+	CubicMeter: {Volume, 1000, 0},
+	Gallon:     {Volume, 3.785411784, 0},
+	CubicFoot:  {Volume, 28.316846592, 0},
+
+	Gram:     {Mass, 1, 0},
+	Kilogram: {Mass, 1000, 0},
+	Ounce:    {Mass, 28.349523125, 0},
+	Pound:    {Mass, 453.59237, 0},
+}
+
+// preferred is the unit each system reports a dimension in. Dimensions
+// missing from a system are left as reported.
+var preferred = map[System]map[string]string{
+	Metric: {
+		Temperature: Celsius, Power: Watt, Energy: KilowattHour, Pressure: Hectopascal,
+		Length: Meter, Speed: KilometersPerHour, Volume: Liter, Mass: Kilogram,
+	},
+	Imperial: {
+		Temperature: Fahrenheit, Power: Watt, Energy: KilowattHour, Pressure: InchesMercury,
+		Length: Foot, Speed: MilesPerHour, Volume: Gallon, Mass: Pound,
+	},
+}
+
+// Dimension returns the dimension of a unit symbol.
+func Dimension(symbol string) (string, bool) {
+	u, ok := table[symbol]
+	return u.dim, ok
+}
+
+// Convert converts v from one unit to another of the same dimension.
+func Convert(v float64, from, to string) (float64, error) {
+	if from == to {
+		return v, nil
+	}
+	f, ok := table[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	t, ok := table[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if f.dim != t.dim {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, f.dim, to, t.dim)
+	}
+	base := v*f.scale + f.offset
+	return (base - t.offset) / t.scale, nil
+}
+
+// Preferred returns the unit sys uses for values reported in symbol. It
+// returns symbol itself for Native, unknown units and dimensions the system
+// has no preference for. Kelvin is kept too, since it mostly measures color
+// temperature, which no system reports in degrees.
+func (sys System) Preferred(symbol string) string {
+	u, ok := table[symbol]
+	if !ok || symbol == Kelvin {
+		return symbol
+	}
+	if p, ok := preferred[sys][u.dim]; ok {
+		return p
+	}
+	return symbol
+}
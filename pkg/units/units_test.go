@@ -0,0 +1,58 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		v        float64
+		from, to string
+		want     float64
+	}{
+		{100, Celsius, Fahrenheit, 212},
+		{32, Fahrenheit, Celsius, 0},
+		{0, Kelvin, Celsius, -273.15},
+		{1.5, Kilowatt, Watt, 1500},
+		{1013.25, Hectopascal, InchesMercury, 29.9213},
+		{10, Mile, Kilometer, 16.09344},
+		{100, KilometersPerHour, MilesPerHour, 62.1371},
+	}
+	for _, tt := range tests {
+		got, err := Convert(tt.v, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("%v %s -> %s: %v", tt.v, tt.from, tt.to, err)
+		}
+		if math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%v %s -> %s = %v, want %v", tt.v, tt.from, tt.to, got, tt.want)
+		}
+	}
+	if _, err := Convert(1, Watt, Celsius); err == nil {
+		t.Errorf("converted across dimensions")
+	}
+	if _, err := Convert(1, "furlong", Meter); err == nil {
+		t.Errorf("converted an unknown unit")
+	}
+}
+
+func TestPreferred(t *testing.T) {
+	if got := Imperial.Preferred(Celsius); got != Fahrenheit {
+		t.Errorf("imperial temperature = %s", got)
+	}
+	if got := Metric.Preferred(Kilowatt); got != Watt {
+		t.Errorf("metric power = %s", got)
+	}
+	if got := Imperial.Preferred(Kelvin); got != Kelvin {
+		t.Errorf("color temperature converted to %s", got)
+	}
+	if got := Native.Preferred(Fahrenheit); got != Fahrenheit {
+		t.Errorf("native changed the unit to %s", got)
+	}
+	if got := Metric.Preferred("lx"); got != "lx" {
+		t.Errorf("unknown unit changed to %s", got)
+	}
+	if _, err := ParseSystem("nautical"); err == nil {
+		t.Errorf("unknown system accepted")
+	}
+}